@@ -0,0 +1,291 @@
+// Package client talks to a SimplyQ leader over its HTTP API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const rawBodyContentType = "application/octet-stream"
+
+// Error is returned when the queue answers an operation with a code other than OK.
+type Error struct {
+	Code Code
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("simplyq: queue returned code %d", e.Code)
+}
+
+// Message is a received message. The body is returned exactly as it was sent.
+type Message struct {
+	ID                    string
+	Body                  []byte
+	ContentType           string
+	ReceiptHandle         string
+	ReceiveCount          int
+	FirstReceiveTimestamp time.Time
+}
+
+type Client struct {
+	BaseURL    string // address of the leader, such as http://127.0.0.1:8080.
+	HTTPClient *http.Client
+
+	HeartbeatInterval time.Duration // how often Heartbeat extends the visibility, half its timeout when zero.
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL:    baseURL,
+		HTTPClient: http.DefaultClient,
+	}
+}
+
+// Send sends a body to a queue and returns the ID of the message.
+func (c *Client) Send(ctx context.Context, queueID string, body []byte, contentType string) (string, error) {
+	if contentType == "" {
+		contentType = rawBodyContentType
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, "/sendMessage", url.Values{"queueID": {queueID}}, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	var result struct {
+		Code    Code `json:"code"`
+		Message struct {
+			ID string
+		} `json:"message"`
+	}
+	if err := c.doJSON(req, &result); err != nil {
+		return "", err
+	}
+	if result.Code != OK {
+		return "", &Error{Code: result.Code}
+	}
+	return result.Message.ID, nil
+}
+
+// Receive receives the next visible message, waiting up to waitTime for one to arrive.
+// It returns a nil message when the queue stays empty.
+func (c *Client) Receive(ctx context.Context, queueID string, waitTime time.Duration) (*Message, error) {
+	query := url.Values{"queueID": {queueID}}
+	if waitTime > 0 {
+		query.Set("waitTimeSeconds", strconv.Itoa(int(waitTime/time.Second)))
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, "/peekMessage", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", rawBodyContentType)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+
+	// A received message is written raw with its metadata in headers, anything else is a JSON code.
+	receiptHandle := resp.Header.Get("X-Receipt-Handle")
+	if receiptHandle == "" {
+		var result struct {
+			Code Code `json:"code"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return nil, err
+		}
+		if result.Code == EMPTY_QUEUE {
+			return nil, nil
+		}
+		return nil, &Error{Code: result.Code}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	receiveCount, _ := strconv.Atoi(resp.Header.Get("X-Receive-Count"))
+	firstReceive, _ := time.Parse(time.RFC3339Nano, resp.Header.Get("X-First-Receive-Timestamp"))
+	return &Message{
+		ID:                    resp.Header.Get("X-Message-Id"),
+		Body:                  body,
+		ContentType:           resp.Header.Get("Content-Type"),
+		ReceiptHandle:         receiptHandle,
+		ReceiveCount:          receiveCount,
+		FirstReceiveTimestamp: firstReceive,
+	}, nil
+}
+
+// Delete deletes a received message by its receipt handle.
+func (c *Client) Delete(ctx context.Context, queueID, receiptHandle string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, "/popMessage", url.Values{
+		"queueID":       {queueID},
+		"receiptHandle": {receiptHandle},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	return c.doCode(req)
+}
+
+// DeleteByID deletes a message by its ID, whether it was received or not. IDs of messages that are
+// not in the queue anymore fail with MESSAGE_NOT_FOUND.
+func (c *Client) DeleteByID(ctx context.Context, queueID, messageID string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, "/deleteMessage", url.Values{
+		"queueID":   {queueID},
+		"messageID": {messageID},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	return c.doCode(req)
+}
+
+// ChangeVisibility hides a received message for timeout from now. A zero timeout releases it right away,
+// so another consumer can receive it.
+func (c *Client) ChangeVisibility(ctx context.Context, queueID, receiptHandle string, timeout time.Duration) error {
+	req, err := c.newRequest(ctx, http.MethodPost, "/changeMessageVisibility", url.Values{
+		"queueID":           {queueID},
+		"receiptHandle":     {receiptHandle},
+		"visibilityTimeout": {strconv.Itoa(int(timeout / time.Second))},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	return c.doCode(req)
+}
+
+// Nack makes a received message visible again right away, recording why it failed on the message.
+func (c *Client) Nack(ctx context.Context, queueID, receiptHandle, reason string) error {
+	return c.failMessage(ctx, "/nackMessage", queueID, receiptHandle, reason)
+}
+
+// Reject dead letters a received message right away, for messages that will never be processed.
+func (c *Client) Reject(ctx context.Context, queueID, receiptHandle, reason string) error {
+	return c.failMessage(ctx, "/rejectMessage", queueID, receiptHandle, reason)
+}
+
+func (c *Client) failMessage(ctx context.Context, path, queueID, receiptHandle, reason string) error {
+	req, err := c.newRequest(ctx, http.MethodPost, path, url.Values{
+		"queueID":       {queueID},
+		"receiptHandle": {receiptHandle},
+		"reason":        {reason},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	return c.doCode(req)
+}
+
+// Purge removes the messages of a queue, its dead letters or both and returns how many were removed.
+// A queue purged within the last PurgeCooldown fails with PURGE_IN_PROGRESS.
+func (c *Client) Purge(ctx context.Context, queueID string, target PurgeTarget) (int, error) {
+	req, err := c.newRequest(ctx, http.MethodPost, "/purgeQueue", url.Values{
+		"queueID": {queueID},
+		"target":  {string(target)},
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+	var result struct {
+		Code   Code `json:"code"`
+		Purged int  `json:"purged"`
+	}
+	if err := c.doJSON(req, &result); err != nil {
+		return 0, err
+	}
+	if result.Code != OK {
+		return 0, &Error{Code: result.Code}
+	}
+	return result.Purged, nil
+}
+
+// Heartbeat keeps a received message hidden while it is being processed, extending its visibility
+// to timeout every HeartbeatInterval until ctx is done. It returns nil once ctx is done, or the first
+// error, after which the message becomes visible again when its current timeout passes.
+//
+//	ctx, stop := context.WithCancel(ctx)
+//	go client.Heartbeat(ctx, queueID, message.ReceiptHandle, 30*time.Second)
+//	process(message)
+//	stop()
+func (c *Client) Heartbeat(ctx context.Context, queueID, receiptHandle string, timeout time.Duration) error {
+	if timeout < 2*time.Second {
+		return fmt.Errorf("simplyq: heartbeat timeout %v is shorter than 2s", timeout)
+	}
+	interval := c.HeartbeatInterval
+	if interval <= 0 {
+		interval = timeout / 2
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := c.ChangeVisibility(ctx, queueID, receiptHandle, timeout); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, method, c.BaseURL+path+"?"+query.Encode(), body)
+}
+
+// doCode sends a request answered with just a code and turns codes other than OK into an Error.
+func (c *Client) doCode(req *http.Request) error {
+	var result struct {
+		Code Code `json:"code"`
+	}
+	if err := c.doJSON(req, &result); err != nil {
+		return err
+	}
+	if result.Code != OK {
+		return &Error{Code: result.Code}
+	}
+	return nil
+}
+
+func (c *Client) doJSON(req *http.Request, result any) error {
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// checkStatus turns a rejected request into an error. The queue rejects some operations with a
+// status and a JSON code, everything else is rejected with the error text.
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	text, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	var result struct {
+		Code *Code `json:"code"`
+	}
+	if json.Unmarshal(text, &result) == nil && result.Code != nil {
+		return &Error{Code: *result.Code}
+	}
+	return fmt.Errorf("simplyq: %s: %s", resp.Status, bytes.TrimSpace(text))
+}
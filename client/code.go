@@ -0,0 +1,40 @@
+package client
+
+import "time"
+
+// Code is the result code the queue returns for an operation. The values are the ones the server
+// writes in the "code" field of its JSON responses.
+type Code int
+
+const (
+	OK Code = iota
+	EMPTY_QUEUE
+	EMPTY_DEAD_LETTER_QUEUE
+	QUEUE_NOT_FOUND
+	QUEUE_ALREADY_EXISTS
+	INVALID_RECEIPT_HANDLE
+	MESSAGE_TOO_LARGE
+	INVALID_QUEUE_CONFIG
+	INVALID_MESSAGE
+	MESSAGE_NOT_FOUND
+	INVALID_BATCH_SIZE
+	INVALID_VISIBILITY_TIMEOUT
+	DEAD_LETTER_TARGET_NOT_FOUND
+	QUEUE_IN_USE
+	INVALID_REDRIVE_TASK
+	REDRIVE_TASK_NOT_FOUND
+	INVALID_PURGE_TARGET
+	PURGE_IN_PROGRESS
+)
+
+// PurgeTarget selects what Purge removes from a queue.
+type PurgeTarget string
+
+const (
+	PurgeMessages    PurgeTarget = "messages"     // ready, in flight, delayed and scheduled messages.
+	PurgeDeadLetters PurgeTarget = "dead_letters" // the queue's own dead letter queue.
+	PurgeAll         PurgeTarget = "all"
+)
+
+// PurgeCooldown is how long a queue refuses another purge with PURGE_IN_PROGRESS.
+const PurgeCooldown = 60 * time.Second
@@ -3,7 +3,9 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Weile-Zheng/simplyQ/internal/server"
 )
@@ -42,5 +44,22 @@ func main() {
 		peers = strings.Split(peersEnv, ",")
 	}
 
-	server.StartNewServer(dataDir, nodeID, bindAddr, raftPort, httpPort, peers)
+	// The leader ticks the queues every TICK_INTERVAL, such as 500ms, with up to TICK_BATCH_SIZE queues per tick.
+	var tick server.TickConfig
+	if interval := os.Getenv("TICK_INTERVAL"); interval != "" {
+		duration, err := time.ParseDuration(interval)
+		if err != nil || duration <= 0 {
+			log.Fatalf("TICK_INTERVAL must be a positive duration, got %q", interval)
+		}
+		tick.Interval = duration
+	}
+	if batchSize := os.Getenv("TICK_BATCH_SIZE"); batchSize != "" {
+		size, err := strconv.Atoi(batchSize)
+		if err != nil || size <= 0 {
+			log.Fatalf("TICK_BATCH_SIZE must be a positive number, got %q", batchSize)
+		}
+		tick.BatchSize = size
+	}
+
+	server.StartNewServer(dataDir, nodeID, bindAddr, raftPort, httpPort, peers, tick)
 }
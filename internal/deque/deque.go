@@ -0,0 +1,147 @@
+// Package deque implements a double ended queue stored in fixed size chunks. Pushing and popping at
+// either end is O(1), indexing is O(1), and chunks are handed back to the garbage collector as soon as
+// they drain, so a queue that empties out does not keep the memory it needed at its peak.
+package deque
+
+const (
+	chunkSize   = 128 // elements per chunk.
+	minRingSize = 4   // the chunk ring never shrinks below this many slots.
+)
+
+type chunk[T any] [chunkSize]T
+
+// Deque is a double ended queue. The zero value is an empty deque ready to use.
+// A Deque is not safe for concurrent use.
+type Deque[T any] struct {
+	ring   []*chunk[T] // chunk pointers used as a ring buffer, its length is zero or a power of two.
+	first  int         // ring slot of the chunk holding the front element.
+	chunks int         // chunks in use, starting at first.
+	head   int         // position of the front element in the first chunk.
+	length int
+
+	spare *chunk[T] // the last released chunk, kept so a deque hovering around a chunk boundary does not allocate.
+}
+
+// Len returns the number of elements.
+func (d *Deque[T]) Len() int {
+	return d.length
+}
+
+// PushBack adds v after the last element.
+func (d *Deque[T]) PushBack(v T) {
+	offset := d.head + d.length
+	if offset/chunkSize == d.chunks {
+		d.grow()
+		d.ring[d.slot(d.chunks)] = d.newChunk()
+		d.chunks++
+	}
+	d.ring[d.slot(offset/chunkSize)][offset%chunkSize] = v
+	d.length++
+}
+
+// PushFront adds v before the first element.
+func (d *Deque[T]) PushFront(v T) {
+	if d.head == 0 {
+		d.grow()
+		d.first = (d.first - 1) & (len(d.ring) - 1)
+		d.ring[d.first] = d.newChunk()
+		d.chunks++
+		d.head = chunkSize
+	}
+	d.head--
+	d.ring[d.first][d.head] = v
+	d.length++
+}
+
+// PopFront removes and returns the first element. It panics if the deque is empty.
+func (d *Deque[T]) PopFront() T {
+	if d.length == 0 {
+		panic("deque: PopFront called on an empty deque")
+	}
+	var zero T
+	first := d.ring[d.first]
+	v := first[d.head]
+	first[d.head] = zero // drop the reference so the element can be collected.
+	d.head++
+	d.length--
+
+	if d.head == chunkSize || d.length == 0 {
+		d.releaseFirst()
+	}
+	return v
+}
+
+// Front returns the first element. It panics if the deque is empty.
+func (d *Deque[T]) Front() T {
+	if d.length == 0 {
+		panic("deque: Front called on an empty deque")
+	}
+	return d.ring[d.first][d.head]
+}
+
+// At returns the element at index i, counted from the front. It panics if i is out of range.
+func (d *Deque[T]) At(i int) T {
+	c, pos := d.locate(i)
+	return d.ring[c][pos]
+}
+
+// Set replaces the element at index i, counted from the front. It panics if i is out of range.
+func (d *Deque[T]) Set(i int, v T) {
+	c, pos := d.locate(i)
+	d.ring[c][pos] = v
+}
+
+func (d *Deque[T]) locate(i int) (int, int) {
+	if i < 0 || i >= d.length {
+		panic("deque: index out of range")
+	}
+	offset := d.head + i
+	return d.slot(offset / chunkSize), offset % chunkSize
+}
+
+// slot returns the ring slot of the c-th chunk in use.
+func (d *Deque[T]) slot(c int) int {
+	return (d.first + c) & (len(d.ring) - 1)
+}
+
+// releaseFirst drops the drained first chunk, which is also the only one when the deque became empty,
+// and shrinks the ring once most of it is unused.
+func (d *Deque[T]) releaseFirst() {
+	d.spare = d.ring[d.first]
+	d.ring[d.first] = nil
+	d.first = (d.first + 1) & (len(d.ring) - 1)
+	d.chunks--
+	d.head = 0
+	if len(d.ring) > minRingSize && d.chunks <= len(d.ring)/4 {
+		d.resize(len(d.ring) / 2)
+	}
+}
+
+// grow makes room in the ring for one more chunk.
+func (d *Deque[T]) grow() {
+	if len(d.ring) == 0 {
+		d.ring = make([]*chunk[T], minRingSize)
+		return
+	}
+	if d.chunks == len(d.ring) {
+		d.resize(2 * len(d.ring))
+	}
+}
+
+// resize moves the chunks in use to the front of a ring with size slots.
+func (d *Deque[T]) resize(size int) {
+	ring := make([]*chunk[T], size)
+	for c := 0; c < d.chunks; c++ {
+		ring[c] = d.ring[d.slot(c)]
+	}
+	d.ring = ring
+	d.first = 0
+}
+
+func (d *Deque[T]) newChunk() *chunk[T] {
+	if spare := d.spare; spare != nil {
+		d.spare = nil
+		return spare
+	}
+	return new(chunk[T])
+}
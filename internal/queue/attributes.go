@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"strconv"
+)
+
+// AttributeType is the data type of a message attribute.
+type AttributeType string
+
+const (
+	AttributeTypeString AttributeType = "String"
+	AttributeTypeNumber AttributeType = "Number"
+	AttributeTypeBinary AttributeType = "Binary"
+)
+
+const (
+	MaxMessageAttributes       = 10  // the most attributes a single message can carry.
+	MaxMessageAttributeNameLen = 256 // the longest attribute name in bytes.
+)
+
+// MessageAttribute is a typed value that travels with a message, so consumers can route it without parsing the body.
+// String and Number attributes use StringValue, numbers are kept in their decimal form. Binary attributes use BinaryValue.
+type MessageAttribute struct {
+	DataType    AttributeType
+	StringValue string `json:",omitempty"`
+	BinaryValue []byte `json:",omitempty"`
+}
+
+// Size returns the number of bytes a message counts against the message size limit: its body plus
+// every attribute's name, type and value.
+func (m Message) Size() int {
+	size := len(m.Body)
+	for name, attribute := range m.Attributes {
+		size += len(name) + len(attribute.DataType) + len(attribute.StringValue) + len(attribute.BinaryValue)
+	}
+	return size
+}
+
+// validAttributes checks the number of attributes, their names and that every value matches its data type.
+func validAttributes(attributes map[string]MessageAttribute) bool {
+	if len(attributes) > MaxMessageAttributes {
+		return false
+	}
+	for name, attribute := range attributes {
+		if !validAttributeName(name) {
+			return false
+		}
+		switch attribute.DataType {
+		case AttributeTypeString:
+			if attribute.StringValue == "" || attribute.BinaryValue != nil {
+				return false
+			}
+		case AttributeTypeNumber:
+			if _, err := strconv.ParseFloat(attribute.StringValue, 64); err != nil || attribute.BinaryValue != nil {
+				return false
+			}
+		case AttributeTypeBinary:
+			if len(attribute.BinaryValue) == 0 || attribute.StringValue != "" {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validAttributeName accepts names of letters, digits, '-', '_' and '.' up to MaxMessageAttributeNameLen bytes.
+func validAttributeName(name string) bool {
+	if name == "" || len(name) > MaxMessageAttributeNameLen {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package queue
+
+import (
+	"time"
+)
+
+// MaxBatchSize is the most entries a single batch operation accepts.
+const MaxBatchSize = 10
+
+// InsertQueueBatch sends up to MaxBatchSize messages in one request. The response holds one result per message, in order.
+func (q *QueueIO) InsertQueueBatch(now time.Time, messages []Message) Response {
+	response := make(chan Response)
+	q.SendChan <- Request{
+		Type:     INSERT_BATCH,
+		Now:      now,
+		Messages: messages,
+		Result:   response,
+	}
+	return <-response
+}
+
+// PeekQueueBatch receives up to maxMessages visible messages in one request, each with its own receipt handle.
+func (q *QueueIO) PeekQueueBatch(now time.Time, maxMessages int) Response {
+	response := make(chan Response)
+	q.SendChan <- Request{
+		Type:        PEEK_BATCH,
+		Now:         now,
+		MaxMessages: maxMessages,
+		Result:      response,
+	}
+	return <-response
+}
+
+// RemoveQueueBatch deletes up to MaxBatchSize in flight messages by their receipt handles. The response holds
+// one result per receipt handle, in order.
+func (q *QueueIO) RemoveQueueBatch(now time.Time, receiptHandles []string) Response {
+	response := make(chan Response)
+	q.SendChan <- Request{
+		Type:           DELETE_BATCH,
+		Now:            now,
+		ReceiptHandles: receiptHandles,
+		Result:         response,
+	}
+	return <-response
+}
+
+func (q *Queue) insertBatch(messages []Message, now time.Time) Response {
+	if len(messages) == 0 || len(messages) > MaxBatchSize {
+		return Response{Code: INVALID_BATCH_SIZE}
+	}
+	batch := make([]Response, len(messages))
+	for i, message := range messages {
+		batch[i] = q.insert(message, now)
+	}
+	return Response{
+		Code:  OK,
+		Batch: batch,
+	}
+}
+
+// receiveBatch stops at the first receive that finds nothing, an empty batch is reported as EMPTY_QUEUE.
+func (q *Queue) receiveBatch(maxMessages int, now time.Time) Response {
+	if maxMessages <= 0 || maxMessages > MaxBatchSize {
+		return Response{Code: INVALID_BATCH_SIZE}
+	}
+	batch := []Response{}
+	for len(batch) < maxMessages {
+		response := q.receive(now)
+		if response.Code != OK {
+			break
+		}
+		batch = append(batch, response)
+	}
+	if len(batch) == 0 {
+		return Response{
+			Code:  EMPTY_QUEUE,
+			Batch: batch,
+		}
+	}
+	return Response{
+		Code:  OK,
+		Batch: batch,
+	}
+}
+
+func (q *Queue) removeBatch(receiptHandles []string) Response {
+	if len(receiptHandles) == 0 || len(receiptHandles) > MaxBatchSize {
+		return Response{Code: INVALID_BATCH_SIZE}
+	}
+	batch := make([]Response, len(receiptHandles))
+	for i, handle := range receiptHandles {
+		batch[i] = q.remove(handle)
+	}
+	return Response{
+		Code:  OK,
+		Batch: batch,
+	}
+}
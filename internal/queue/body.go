@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// BodyEncoding tells how a message body is written as a JSON string.
+type BodyEncoding string
+
+const (
+	BodyEncodingUTF8   BodyEncoding = "utf-8"
+	BodyEncodingBase64 BodyEncoding = "base64"
+)
+
+// MarshalJSON writes the body as a plain string when it is valid UTF-8 and as base64 otherwise,
+// so binary bodies survive the Raft log and snapshots unchanged.
+func (m Message) MarshalJSON() ([]byte, error) {
+	type message Message
+	body, encoding := string(m.Body), BodyEncodingUTF8
+	if !utf8.Valid(m.Body) {
+		body, encoding = base64.StdEncoding.EncodeToString(m.Body), BodyEncodingBase64
+	}
+	return json.Marshal(struct {
+		message
+		Body         string
+		BodyEncoding BodyEncoding
+	}{message(m), body, encoding})
+}
+
+// UnmarshalJSON reads a body written by MarshalJSON. A missing BodyEncoding is read as UTF-8.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	type message Message
+	aux := struct {
+		*message
+		Body         string
+		BodyEncoding BodyEncoding
+	}{message: (*message)(m)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	switch aux.BodyEncoding {
+	case "", BodyEncodingUTF8:
+		if aux.Body != "" {
+			m.Body = []byte(aux.Body)
+		}
+	case BodyEncodingBase64:
+		body, err := base64.StdEncoding.DecodeString(aux.Body)
+		if err != nil {
+			return fmt.Errorf("invalid base64 message body: %w", err)
+		}
+		m.Body = body
+	default:
+		return fmt.Errorf("unknown message body encoding %q", aux.BodyEncoding)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package queue
+
+import "time"
+
+// GetConfig returns the configuration of the running queue in Response.Config.
+func (q *QueueIO) GetConfig(now time.Time) Response {
+	response := make(chan Response)
+	q.SendChan <- Request{
+		Type:   GET_CONFIG,
+		Now:    now,
+		Result: response,
+	}
+	return <-response
+}
+
+// ConfigUpdate changes the fields of a queue configuration that are set and keeps the others. The Name
+// and Type can not change, they are only accepted when they match, so a configuration read from the
+// queue can be sent back as a whole. A RedrivePolicy replaces the current one, an empty
+// DeadLetterTargetQueue removes it.
+type ConfigUpdate struct {
+	Name              *string
+	Type              *QueueType
+	RetentionPeriod   *time.Duration
+	VisibilityTimeout *time.Duration
+	MaxReceiveCount   *uint16
+	MaxMessageSize    *uint32
+	DelaySeconds      *uint32
+	RedrivePolicy     *RedrivePolicy
+
+	ContentBasedDeduplication *bool
+	DeduplicationWindow       *time.Duration
+}
+
+// Apply returns config with the fields set in the update changed.
+func (u ConfigUpdate) Apply(config QueueConfig) QueueConfig {
+	set(&config.Name, u.Name)
+	set(&config.Type, u.Type)
+	set(&config.RetentionPeriod, u.RetentionPeriod)
+	set(&config.VisibilityTimeout, u.VisibilityTimeout)
+	set(&config.MaxReceiveCount, u.MaxReceiveCount)
+	set(&config.MaxMessageSize, u.MaxMessageSize)
+	set(&config.DelaySeconds, u.DelaySeconds)
+	set(&config.RedrivePolicy, u.RedrivePolicy)
+	set(&config.ContentBasedDeduplication, u.ContentBasedDeduplication)
+	set(&config.DeduplicationWindow, u.DeduplicationWindow)
+	return config
+}
+
+func set[T any](field *T, value *T) {
+	if value != nil {
+		*field = *value
+	}
+}
+
+// UpdateConfig changes the configuration of the running queue and returns the new one in Response.Config.
+// Messages already in the queue keep their timestamps and receive counts, the new limits apply from the
+// next request on.
+func (q *QueueIO) UpdateConfig(now time.Time, update ConfigUpdate) Response {
+	response := make(chan Response)
+	q.SendChan <- Request{
+		Type:         UPDATE_CONFIG,
+		Now:          now,
+		ConfigUpdate: update,
+		Result:       response,
+	}
+	return <-response
+}
+
+func (q *Queue) getConfig() Response {
+	return Response{
+		Message: Message{},
+		Code:    OK,
+		Config:  q.Config,
+	}
+}
+
+func (q *Queue) updateConfig(update ConfigUpdate) Response {
+	config := update.Apply(q.Config)
+	if config.Name != q.Config.Name || config.Type != q.Config.Type || config.Validate() != OK {
+		return Response{
+			Message: Message{},
+			Code:    INVALID_QUEUE_CONFIG,
+		}
+	}
+
+	if config.RetentionPeriod != q.Config.RetentionPeriod {
+		q.nextExpiry = time.Time{} // the next request scans the queue with the new retention period.
+	}
+	q.Config = config
+	return q.getConfig()
+}
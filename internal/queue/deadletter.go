@@ -0,0 +1,103 @@
+package queue
+
+import "time"
+
+// DeadLetterReason tells why a message was dead lettered.
+type DeadLetterReason string
+
+const (
+	DeadLetterMaxReceives DeadLetterReason = "max_receives" // received MaxReceiveCount times without being deleted.
+	DeadLetterExpired     DeadLetterReason = "expired"      // outlived the retention period of a queue with a redrive policy.
+	DeadLetterRejected    DeadLetterReason = "rejected"     // rejected by a consumer.
+	DeadLetterInvalid     DeadLetterReason = "invalid"      // moved to a queue whose limits it does not fit.
+)
+
+// DeadLetterInfo records where a dead lettered message came from and why it was dead lettered.
+type DeadLetterInfo struct {
+	SourceQueue    string
+	Reason         DeadLetterReason
+	ReceiveCount   uint16
+	DeadLetteredAt time.Time
+}
+
+// NackMessage makes the in flight message identified by the receipt handle visible again right away,
+// recording why the consumer failed to process it in the message's FailureReason.
+func (q *QueueIO) NackMessage(now time.Time, receiptHandle string, reason string) Response {
+	response := make(chan Response)
+	q.SendChan <- Request{
+		Type:          NACK,
+		Now:           now,
+		ReceiptHandle: receiptHandle,
+		Reason:        reason,
+		Result:        response,
+	}
+	return <-response
+}
+
+// RejectMessage dead letters the in flight message identified by the receipt handle right away,
+// for messages the consumer knows it will never be able to process.
+func (q *QueueIO) RejectMessage(now time.Time, receiptHandle string, reason string) Response {
+	response := make(chan Response)
+	q.SendChan <- Request{
+		Type:          REJECT,
+		Now:           now,
+		ReceiptHandle: receiptHandle,
+		Reason:        reason,
+		Result:        response,
+	}
+	return <-response
+}
+
+func (q *Queue) nack(receiptHandle string, reason string, now time.Time) Response {
+	if inFlight, exists := q.InFlight[receiptHandle]; exists {
+		inFlight.Message.FailureReason = reason
+		q.InFlight[receiptHandle] = inFlight
+	}
+	return q.changeVisibility(receiptHandle, 0, now)
+}
+
+func (q *Queue) reject(receiptHandle string, reason string, now time.Time) Response {
+	inFlight, exists := q.InFlight[receiptHandle]
+	if !exists {
+		return Response{
+			Message: Message{},
+			Code:    INVALID_RECEIPT_HANDLE,
+		}
+	}
+	delete(q.InFlight, receiptHandle)
+	inFlight.Message.FailureReason = reason
+	q.deadLetter(inFlight.Message, DeadLetterRejected, now)
+	if q.Config.Type == QueueTypeFIFO {
+		q.notify() // the next message of the group is unblocked.
+	}
+	return Response{
+		Message: inFlight.Message,
+		Code:    OK,
+	}
+}
+
+// deadLetter records why a message is dead lettered and moves it to the DeadLetterQueue, or sets it
+// aside for the redrive target when the queue has a redrive policy.
+func (q *Queue) deadLetter(message Message, reason DeadLetterReason, now time.Time) {
+	q.untrack(message)
+	message.DeadLetter = &DeadLetterInfo{
+		SourceQueue:    q.ID,
+		Reason:         reason,
+		ReceiveCount:   message.ReceiveCount,
+		DeadLetteredAt: now,
+	}
+	if q.Config.RedrivePolicy.enabled() {
+		q.PendingDeadLetters = append(q.PendingDeadLetters, message)
+		return
+	}
+	q.DeadLetterQueue = append(q.DeadLetterQueue, message)
+}
+
+// withDeadLetters hands the messages set aside for the redrive target to the caller of a request,
+// which moves them right away so that the move is part of the same replicated command.
+func (q *Queue) withDeadLetters(response Response) Response {
+	if len(q.PendingDeadLetters) > 0 {
+		response.DeadLetters, q.PendingDeadLetters = q.PendingDeadLetters, nil
+	}
+	return response
+}
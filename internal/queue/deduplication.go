@@ -0,0 +1,81 @@
+package queue
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"sort"
+	"time"
+)
+
+// DefaultDeduplicationWindow is used when a queue does not configure a DeduplicationWindow.
+const DefaultDeduplicationWindow = 5 * time.Minute
+
+// DeduplicationEntry remembers the message first sent with a deduplication ID until ExpiresAt.
+type DeduplicationEntry struct {
+	Message   Message
+	ExpiresAt time.Time
+}
+
+// deduplicationKey returns the ID a message is deduplicated by, or an empty string if it is not deduplicated.
+func (q *Queue) deduplicationKey(message Message) string {
+	if message.DeduplicationID != "" {
+		return message.DeduplicationID
+	}
+	if q.Config.ContentBasedDeduplication {
+		sum := sha256.Sum256(message.Body)
+		return hex.EncodeToString(sum[:])
+	}
+	return ""
+}
+
+// remember records a sent message so sends with the same key within the deduplication window return it instead.
+func (q *Queue) remember(key string, message Message, now time.Time) {
+	if key == "" {
+		return
+	}
+	window := q.Config.DeduplicationWindow
+	if window <= 0 {
+		window = DefaultDeduplicationWindow
+	}
+	entry := DeduplicationEntry{
+		Message:   message,
+		ExpiresAt: now.Add(window),
+	}
+	q.Deduplication[key] = entry
+
+	// New entries usually expire last, only a shortened window makes them expire before older ones.
+	i := len(q.deduplicationOrder)
+	for i > 0 && q.Deduplication[q.deduplicationOrder[i-1]].ExpiresAt.After(entry.ExpiresAt) {
+		i--
+	}
+	q.deduplicationOrder = slices.Insert(q.deduplicationOrder, i, key)
+}
+
+// forgetDeduplication drops deduplication entries whose window has passed.
+func (q *Queue) forgetDeduplication(now time.Time) {
+	for len(q.deduplicationOrder) > 0 {
+		key := q.deduplicationOrder[0]
+		if entry, exists := q.Deduplication[key]; exists && now.Before(entry.ExpiresAt) {
+			return
+		}
+		delete(q.Deduplication, key)
+		q.deduplicationOrder = q.deduplicationOrder[1:]
+	}
+}
+
+// deduplicationOrder rebuilds the expiry order of restored deduplication entries.
+func deduplicationOrder(entries map[string]DeduplicationEntry) []string {
+	order := make([]string, 0, len(entries))
+	for key := range entries {
+		order = append(order, key)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		a, b := entries[order[i]].ExpiresAt, entries[order[j]].ExpiresAt
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return order[i] < order[j]
+	})
+	return order
+}
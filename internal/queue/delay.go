@@ -0,0 +1,86 @@
+package queue
+
+import (
+	"sort"
+	"time"
+)
+
+// MaxDelaySeconds is the longest delay a message or queue can be configured with.
+const MaxDelaySeconds uint32 = 15 * 60
+
+// DelayedMessage is a sent message that is hidden from receivers until DueAt,
+// either because of a delay or because it is scheduled for a DeliverAt.
+type DelayedMessage struct {
+	Message Message
+	DueAt   time.Time
+}
+
+// delay returns how long a newly sent message stays hidden.
+func (q *Queue) delay(message Message) time.Duration {
+	if message.DelaySeconds > 0 {
+		return time.Duration(message.DelaySeconds) * time.Second
+	}
+	return time.Duration(q.Config.DelaySeconds) * time.Second
+}
+
+// canSchedule reports whether a message's DeliverAt falls within the retention period,
+// a message scheduled any later would expire before it is delivered.
+func (q *Queue) canSchedule(message Message, now time.Time) bool {
+	if q.Config.RetentionPeriod <= 0 || message.DeliverAt.IsZero() {
+		return true
+	}
+	return !message.DeliverAt.After(now.Add(q.Config.RetentionPeriod))
+}
+
+// releaseDue moves the messages whose due time has come to the tail of the queue and returns the rest.
+func (q *Queue) releaseDue(delayed []DelayedMessage, now time.Time) []DelayedMessage {
+	due := 0
+	for due < len(delayed) && !now.Before(delayed[due].DueAt) {
+		q.ready.push(delayed[due].Message)
+		q.track(delayed[due].Message, messageLocation{state: stateReady})
+		due++
+	}
+	if due > 0 {
+		q.notify()
+	}
+	return delayed[due:]
+}
+
+// cancelScheduled removes a scheduled message by its ID.
+func (q *Queue) cancelScheduled(messageID string) Response {
+	if location, exists := q.locations[messageID]; exists && location.state == stateScheduled {
+		delete(q.locations, messageID)
+		var message Message
+		q.Scheduled, message = removeDelayed(q.Scheduled, location)
+		return Response{
+			Message: message,
+			Code:    OK,
+		}
+	}
+	return Response{
+		Message: Message{},
+		Code:    MESSAGE_NOT_FOUND,
+	}
+}
+
+// filterDelayed keeps the delayed messages accepted by keep in place and returns how many were dropped.
+func filterDelayed(delayed []DelayedMessage, keep func(Message) bool) ([]DelayedMessage, int) {
+	kept := delayed[:0]
+	for _, message := range delayed {
+		if keep(message.Message) {
+			kept = append(kept, message)
+		}
+	}
+	return kept, len(delayed) - len(kept)
+}
+
+// insertDelayed adds a delayed message after every message due at or before it.
+func insertDelayed(delayed []DelayedMessage, message DelayedMessage) []DelayedMessage {
+	i := sort.Search(len(delayed), func(i int) bool {
+		return delayed[i].DueAt.After(message.DueAt)
+	})
+	delayed = append(delayed, DelayedMessage{})
+	copy(delayed[i+1:], delayed[i:])
+	delayed[i] = message
+	return delayed
+}
@@ -0,0 +1,115 @@
+package queue
+
+import (
+	"sort"
+	"time"
+)
+
+type messageState int
+
+const (
+	stateReady messageState = iota
+	stateInFlight
+	stateDelayed
+	stateScheduled
+)
+
+// messageLocation tells where a message that is neither deleted nor dead lettered is kept,
+// so it can be deleted by its ID without searching the queue.
+type messageLocation struct {
+	state          messageState
+	sequenceNumber uint64
+	receiptHandle  string    // set while the message is in flight.
+	dueAt          time.Time // set while the message is delayed or scheduled.
+}
+
+// DeleteMessage deletes a message by its ID, whether it is ready, in flight, delayed or scheduled.
+// Unknown IDs, and IDs of messages that were already deleted or dead lettered, return MESSAGE_NOT_FOUND.
+func (q *QueueIO) DeleteMessage(now time.Time, messageID string) Response {
+	response := make(chan Response)
+	q.SendChan <- Request{
+		Type:      DELETE_BY_ID,
+		Now:       now,
+		MessageID: messageID,
+		Result:    response,
+	}
+	return <-response
+}
+
+func (q *Queue) deleteMessage(messageID string) Response {
+	location, exists := q.locations[messageID]
+	if !exists {
+		return Response{
+			Message: Message{},
+			Code:    MESSAGE_NOT_FOUND,
+		}
+	}
+	delete(q.locations, messageID)
+
+	switch location.state {
+	case stateReady:
+		q.ready.remove(location.sequenceNumber)
+	case stateInFlight:
+		delete(q.InFlight, location.receiptHandle)
+		if q.Config.Type == QueueTypeFIFO {
+			q.notify() // the next message of the group is unblocked.
+		}
+	case stateDelayed:
+		q.Delayed, _ = removeDelayed(q.Delayed, location)
+	case stateScheduled:
+		q.Scheduled, _ = removeDelayed(q.Scheduled, location)
+	}
+	return Response{
+		Message: Message{ID: messageID},
+		Code:    OK,
+	}
+}
+
+// removeDelayed finds a delayed message by its due time, the slice is ordered by it.
+func removeDelayed(delayed []DelayedMessage, location messageLocation) ([]DelayedMessage, Message) {
+	i := sort.Search(len(delayed), func(i int) bool {
+		return !delayed[i].DueAt.Before(location.dueAt)
+	})
+	for ; i < len(delayed) && delayed[i].DueAt.Equal(location.dueAt); i++ {
+		if message := delayed[i].Message; message.SequenceNumber == location.sequenceNumber {
+			return append(delayed[:i], delayed[i+1:]...), message
+		}
+	}
+	return delayed, Message{}
+}
+
+// track records where a message is kept now. Messages without an ID can not be deleted by it.
+func (q *Queue) track(message Message, location messageLocation) {
+	if message.ID == "" {
+		return
+	}
+	if tracked, exists := q.locations[message.ID]; exists && tracked.sequenceNumber > message.SequenceNumber {
+		return // the ID was reused, the newest message keeps it.
+	}
+	location.sequenceNumber = message.SequenceNumber
+	q.locations[message.ID] = location
+}
+
+// untrack forgets a message that left the queue. Another message sent with the same ID stays tracked.
+func (q *Queue) untrack(message Message) {
+	if location, exists := q.locations[message.ID]; exists && location.sequenceNumber == message.SequenceNumber {
+		delete(q.locations, message.ID)
+	}
+}
+
+// trackAll rebuilds the locations of a restored queue.
+func (q *Queue) trackAll() {
+	q.locations = make(map[string]messageLocation)
+	for _, message := range q.ready.messages() {
+		q.track(message, messageLocation{state: stateReady})
+	}
+	for handle, inFlight := range q.InFlight {
+		q.track(inFlight.Message, messageLocation{state: stateInFlight, receiptHandle: handle})
+	}
+	for _, delayed := range q.Delayed {
+		q.track(delayed.Message, messageLocation{state: stateDelayed, dueAt: delayed.DueAt})
+	}
+	for _, scheduled := range q.Scheduled {
+		q.track(scheduled.Message, messageLocation{state: stateScheduled, dueAt: scheduled.DueAt})
+	}
+}
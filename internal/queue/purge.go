@@ -0,0 +1,66 @@
+package queue
+
+import "time"
+
+// PurgeCooldown is how long a queue refuses another purge after it was purged.
+const PurgeCooldown = 60 * time.Second
+
+// PurgeTarget selects which messages of a queue a purge removes.
+type PurgeTarget string
+
+const (
+	PurgeMessages    PurgeTarget = "messages"     // ready, in flight, delayed and scheduled messages.
+	PurgeDeadLetters PurgeTarget = "dead_letters" // the queue's own dead letter queue.
+	PurgeAll         PurgeTarget = "all"
+)
+
+// PurgeQueue removes the messages selected by target and reports how many were removed in Response.Purged.
+// The configuration and deduplication entries are kept. A queue purged less than PurgeCooldown ago
+// refuses with PURGE_IN_PROGRESS.
+func (q *QueueIO) PurgeQueue(now time.Time, target PurgeTarget) Response {
+	response := make(chan Response)
+	q.SendChan <- Request{
+		Type:   PURGE,
+		Now:    now,
+		Purge:  target,
+		Result: response,
+	}
+	return <-response
+}
+
+func (q *Queue) purge(target PurgeTarget, now time.Time) Response {
+	switch target {
+	case PurgeMessages, PurgeDeadLetters, PurgeAll:
+	default:
+		return Response{
+			Message: Message{},
+			Code:    INVALID_PURGE_TARGET,
+		}
+	}
+	if !q.LastPurgedAt.IsZero() && now.Before(q.LastPurgedAt.Add(PurgeCooldown)) {
+		return Response{
+			Message: Message{},
+			Code:    PURGE_IN_PROGRESS,
+		}
+	}
+
+	purged := 0
+	if target != PurgeDeadLetters {
+		purged += q.ready.len() + len(q.InFlight) + len(q.Delayed) + len(q.Scheduled)
+		q.ready = newMessageStore(q.Config.Type, nil)
+		q.InFlight = make(map[string]InFlightMessage)
+		q.Delayed = []DelayedMessage{}
+		q.Scheduled = []DelayedMessage{}
+		q.locations = make(map[string]messageLocation)
+	}
+	if target != PurgeMessages {
+		purged += len(q.DeadLetterQueue)
+		q.DeadLetterQueue = []Message{}
+	}
+	q.LastPurgedAt = now
+	return Response{
+		Message: Message{},
+		Code:    OK,
+		Purged:  purged,
+	}
+}
@@ -3,24 +3,71 @@ package queue
 /**
  * Queue package provides a simple implementation of thread safe message queue.
 
- * 
+ *
  */
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
 	"time"
 )
 
 type Message struct {
-	ID        string
-	Body      string
-	TimeStamp time.Time
+	ID              string
+	Body            []byte
+	ContentType     string // media type of the body, such as text/plain or application/x-protobuf.
+	TimeStamp       time.Time
+	MessageGroupID  string    // FIFO queues deliver messages of the same group strictly in order, one at a time.
+	DeduplicationID string    // sends with the same ID within the deduplication window are only enqueued once.
+	DelaySeconds    uint32    // hides the message after it is sent, overriding the queue's DelaySeconds when set.
+	DeliverAt       time.Time // schedules the message for an absolute time, taking precedence over DelaySeconds.
+	Attributes      map[string]MessageAttribute
+	Priority        int    // priority queues deliver messages with a higher priority first.
+	SequenceNumber  uint64 // assigned by the queue on send, increasing in the order messages are accepted.
+
+	ReceiveCount          uint16    // the number of times the message has been received.
+	FirstReceiveTimestamp time.Time // zero until the message is received for the first time.
+	LastReceiveTimestamp  time.Time
+
+	FailureReason string          // why a consumer last nacked or rejected the message.
+	DeadLetter    *DeadLetterInfo // set once the message is dead lettered.
 }
 
 type Queue struct {
 	ID              string
 	Config          QueueConfig
-	Messages        []Message
+	Messages        []Message                  // messages ready to be received in delivery order, only filled in snapshots.
+	InFlight        map[string]InFlightMessage // in flight messages keyed by receipt handle.
+	Delayed         []DelayedMessage           // delayed messages ordered by the time they become visible.
+	Scheduled       []DelayedMessage           // messages sent with a DeliverAt, ordered by the time they become visible.
 	DeadLetterQueue []Message
+
+	PendingDeadLetters []Message // dead letters waiting to be moved to the redrive target by the queue manager.
+
+	Deduplication map[string]DeduplicationEntry // recently sent messages keyed by deduplication ID.
+
+	ExpiredMessages    uint64 // messages dropped from the queue after the retention period.
+	ExpiredDeadLetters uint64 // messages dropped from the dead letter queue after the retention period.
+	SequenceNumber     uint64 // the SequenceNumber of the last message sent.
+	ReceiveSequence    uint64 // counts every receive, so a receipt handle is never handed out twice.
+
+	LastPurgedAt time.Time // when the queue was last purged, it refuses another purge until PurgeCooldown passed.
+
+	ready              messageStore // the running queue keeps its ready messages here instead of in Messages.
+	nextExpiry         time.Time    // no message expires before this time.
+	deduplicationOrder []string     // deduplication IDs in the order they expire.
+	waiters            []waiter     // receivers waiting for a message to become ready.
+
+	locations map[string]messageLocation // where every message that can still be deleted is kept, keyed by message ID.
+}
+
+// InFlightMessage is a received message that is hidden from other consumers until VisibleAt.
+type InFlightMessage struct {
+	Message       Message
+	ReceiptHandle string
+	VisibleAt     time.Time
 }
 
 type QueueConfig struct {
@@ -30,69 +77,157 @@ type QueueConfig struct {
 	VisibilityTimeout time.Duration
 	MaxReceiveCount   uint16
 	MaxMessageSize    uint32
+	DelaySeconds      uint32 // default delay of every message sent to the queue.
+	RedrivePolicy     RedrivePolicy
+
+	ContentBasedDeduplication bool          // deduplicate messages without a DeduplicationID by the SHA-256 of their body.
+	DeduplicationWindow       time.Duration // defaults to DefaultDeduplicationWindow when zero.
+}
+
+// Validate checks the queue configuration before a queue is created from it.
+func (c QueueConfig) Validate() Code {
+	switch c.Type {
+	case "", QueueTypeStandard, QueueTypeFIFO, QueueTypePriority:
+	default:
+		return INVALID_QUEUE_CONFIG
+	}
+	if c.MaxMessageSize > MaxMessageSizeLimit || c.DelaySeconds > MaxDelaySeconds {
+		return INVALID_QUEUE_CONFIG
+	}
+	if c.RedrivePolicy.enabled() && (c.RedrivePolicy.MaxReceiveCount == 0 || c.RedrivePolicy.DeadLetterTargetQueue == c.Name) {
+		return INVALID_QUEUE_CONFIG
+	}
+	return OK
+}
+
+// maxReceiveCount returns how often a message can be received before it is dead lettered, zero is unlimited.
+func (c QueueConfig) maxReceiveCount() uint16 {
+	if c.RedrivePolicy.enabled() {
+		return c.RedrivePolicy.MaxReceiveCount
+	}
+	return c.MaxReceiveCount
+}
+
+// messageSizeLimit returns the largest accepted message size. Zero MaxMessageSize falls back to the cluster limit.
+func (c QueueConfig) messageSizeLimit() uint32 {
+	if c.MaxMessageSize == 0 || c.MaxMessageSize > MaxMessageSizeLimit {
+		return MaxMessageSizeLimit
+	}
+	return c.MaxMessageSize
 }
 
 type Request struct {
-	Type    opType
-	Message Message
-	Result  chan Response
+	Type              opType
+	Message           Message
+	MessageID         string
+	ReceiptHandle     string
+	Messages          []Message // entries of a batch send.
+	ReceiptHandles    []string  // entries of a batch delete.
+	MaxMessages       int       // the most messages a batch receive or take hands out.
+	Selector          MessageSelector
+	Purge             PurgeTarget
+	ConfigUpdate      ConfigUpdate
+	Reason            string
+	VisibilityTimeout time.Duration
+	Now               time.Time // the time of the command the request is part of, the queue never reads the clock itself.
+	Waiter            chan struct{}
+	Deadline          time.Time
+	Result            chan Response
 }
 
 type Response struct {
-	Message Message
-	Code    Code
+	Message       Message
+	ReceiptHandle string
+	Code          Code
+	Batch         []Response // one result per entry of a batch operation.
+	DeadLetters   []Message  // messages a receive moved out of the queue for its redrive target.
+	Purged        int        // the number of messages a purge removed.
+	Config        QueueConfig
 }
 
 type QueueIO struct {
 	SendChan chan<- Request
-	Snapshot <-chan Queue
+	Snapshot chan<- chan Queue
 	End      chan<- any
 }
 
 // InsertQueue sends an insert request to the queue and waits for a response.
-func (q *QueueIO) InsertQueue(message Message) Response {
+func (q *QueueIO) InsertQueue(now time.Time, message Message) Response {
 	response := make(chan Response)
 	q.SendChan <- Request{
 		Type:    INSERT,
+		Now:     now,
 		Message: message,
 		Result:  response,
 	}
 	return <-response
 }
 
-// PeekQueue sends a peek request to the queue and waits for a response.
-func (q *QueueIO) PeekQueue() Response {
+// PeekQueue receives the next visible message from the queue. The message is hidden from
+// other consumers for the visibility timeout and can only be deleted with the returned receipt handle.
+func (q *QueueIO) PeekQueue(now time.Time) Response {
 	response := make(chan Response)
 	q.SendChan <- Request{
 		Type:   PEEK,
+		Now:    now,
 		Result: response,
 	}
 	return <-response
 }
 
-// RemoveQueue sends a delete request to the queue and waits for a response.
-func (q *QueueIO) RemoveQueue() Response {
+// RemoveQueue deletes the in flight message identified by the receipt handle.
+func (q *QueueIO) RemoveQueue(now time.Time, receiptHandle string) Response {
 	response := make(chan Response)
 	q.SendChan <- Request{
-		Type:   DELETE,
-		Result: response,
+		Type:          DELETE,
+		Now:           now,
+		ReceiptHandle: receiptHandle,
+		Result:        response,
 	}
 	return <-response
 }
 
 // Requeue move a message from the dead letter queue back to the main queue.
-func (q *QueueIO) Requeue() Response {
+func (q *QueueIO) Requeue(now time.Time) Response {
 	response := make(chan Response)
 	q.SendChan <- Request{
 		Type:   REQUEUE,
+		Now:    now,
+		Result: response,
+	}
+	return <-response
+}
+
+// CancelScheduled removes a message that is scheduled for a later DeliverAt before it is delivered.
+func (q *QueueIO) CancelScheduled(now time.Time, messageID string) Response {
+	response := make(chan Response)
+	q.SendChan <- Request{
+		Type:      CANCEL_SCHEDULED,
+		Now:       now,
+		MessageID: messageID,
+		Result:    response,
+	}
+	return <-response
+}
+
+// Tick applies the time driven transitions that are due at now without doing anything else: in flight messages
+// whose visibility timeout passed become visible, delayed and scheduled messages are released and messages past
+// the retention period expire. Every request does the same first, ticks make it happen on an idle queue.
+func (q *QueueIO) Tick(now time.Time) Response {
+	response := make(chan Response)
+	q.SendChan <- Request{
+		Type:   TICK,
+		Now:    now,
 		Result: response,
 	}
 	return <-response
 }
 
-// SnapshotQueue sends a print request to the queue and returns a list of messages.
+// SnapshotQueue returns a copy of the queue state, including in flight and dead letter messages.
 func (q *QueueIO) SnapshotQueue() Queue {
-	return <-q.Snapshot
+	result := make(chan Queue)
+	q.Snapshot <- result
+	return <-result
 }
 
 func (q *QueueIO) Close() {
@@ -100,88 +235,112 @@ func (q *QueueIO) Close() {
 }
 
 func MakeQueue(id string, config QueueConfig) *QueueIO {
-	send, snapshot, end := make(chan Request), make(chan Queue), make(chan any)
+	return RestoreQueue(Queue{
+		ID:     id,
+		Config: config,
+	})
+}
+
+// numberUnsequenced gives a SequenceNumber to messages restored from a snapshot written before messages
+// had one. Deletes and ordering tell messages apart by it, so it must not stay zero. In flight messages
+// were sent before the ready ones, the order is the same on every replica restoring the snapshot.
+func (q *Queue) numberUnsequenced() {
+	next := func(message *Message) {
+		if message.SequenceNumber == 0 {
+			q.SequenceNumber++
+			message.SequenceNumber = q.SequenceNumber
+		}
+	}
+
+	handles := make([]string, 0, len(q.InFlight))
+	for handle, inFlight := range q.InFlight {
+		handles = append(handles, handle)
+		q.SequenceNumber = max(q.SequenceNumber, inFlight.Message.SequenceNumber)
+	}
+	for _, messages := range [][]Message{q.Messages, q.DeadLetterQueue} {
+		for _, message := range messages {
+			q.SequenceNumber = max(q.SequenceNumber, message.SequenceNumber)
+		}
+	}
+	for _, delayed := range [][]DelayedMessage{q.Delayed, q.Scheduled} {
+		for _, message := range delayed {
+			q.SequenceNumber = max(q.SequenceNumber, message.Message.SequenceNumber)
+		}
+	}
+
+	sort.Strings(handles)
+	for _, handle := range handles {
+		inFlight := q.InFlight[handle]
+		next(&inFlight.Message)
+		q.InFlight[handle] = inFlight
+	}
+	for _, messages := range [][]Message{q.Messages, q.DeadLetterQueue} {
+		for i := range messages {
+			next(&messages[i])
+		}
+	}
+	for _, delayed := range [][]DelayedMessage{q.Delayed, q.Scheduled} {
+		for i := range delayed {
+			next(&delayed[i].Message)
+		}
+	}
+}
+
+// RestoreQueue starts a queue from the state returned by SnapshotQueue.
+func RestoreQueue(queue Queue) *QueueIO {
+	send, snapshot, end := make(chan Request), make(chan chan Queue), make(chan any)
 	queueIO := QueueIO{
 		SendChan: send,
 		Snapshot: snapshot,
 		End:      end,
 	}
 
-	queue := Queue{
-		Config:          config,
-		ID:              id,
-		Messages:        []Message{},
-		DeadLetterQueue: []Message{},
+	if queue.Config.Type == "" {
+		queue.Config.Type = QueueTypeStandard
 	}
-
-	queueHeadReceiveCount := uint16(0) // the number of times the head message has been received.
+	if queue.InFlight == nil {
+		queue.InFlight = map[string]InFlightMessage{}
+	}
+	if queue.Delayed == nil {
+		queue.Delayed = []DelayedMessage{}
+	}
+	if queue.Scheduled == nil {
+		queue.Scheduled = []DelayedMessage{}
+	}
+	if queue.DeadLetterQueue == nil {
+		queue.DeadLetterQueue = []Message{}
+	}
+	if queue.Deduplication == nil {
+		queue.Deduplication = map[string]DeduplicationEntry{}
+	}
+	queue.numberUnsequenced()
+	queue.ready = newMessageStore(queue.Config.Type, queue.Messages)
+	queue.Messages = nil
+	queue.deduplicationOrder = deduplicationOrder(queue.Deduplication)
+	queue.trackAll()
 
 	go func() {
 		for {
 			select {
 			case req := <-send:
-				switch req.Type {
-				case INSERT:
-					queue.Messages = append(queue.Messages, req.Message)
-					req.Result <- Response{
-						Message: req.Message,
-						Code:    OK,
-					}
-				case PEEK:
-					if len(queue.Messages) > 0 {
-						queueHeadReceiveCount++
-						message := queue.Messages[0]
-						if queueHeadReceiveCount == queue.Config.MaxReceiveCount {
-							// Move the message to the dead letter queue
-							queue.DeadLetterQueue = append(queue.DeadLetterQueue, queue.Messages[0])
-							queue.Messages = queue.Messages[1:]
-							queueHeadReceiveCount = 0 // Reset the receive count after moving to dead letter queue
-						}
-						req.Result <- Response{
-							Message: message,
-							Code:    OK,
-						}
-					} else {
-						req.Result <- Response{
-							Message: Message{},
-							Code:    EMPTY_QUEUE,
-						}
-					}
-				case DELETE:
-					if len(queue.Messages) > 0 {
-						queue.Messages = queue.Messages[1:]
-						queueHeadReceiveCount = 0 // Reset the receive count after deletion
-						req.Result <- Response{
-							Message: Message{},
-							Code:    OK,
-						}
-					} else {
-						req.Result <- Response{
-							Message: Message{},
-							Code:    EMPTY_QUEUE,
-						}
-					}
-				case REQUEUE:
-					if len(queue.DeadLetterQueue) > 0 {
-						message := queue.DeadLetterQueue[0]
-						queue.DeadLetterQueue = queue.DeadLetterQueue[1:]
-						queue.Messages = append(queue.Messages, message)
-						req.Result <- Response{
-							Message: message,
-							Code:    OK,
-						}
-					} else {
-						req.Result <- Response{
-							Message: Message{},
-							Code:    EMPTY_DEAD_LETTER_QUEUE,
-						}
-					}
+				if req.Type == WAIT {
+					// Waiters only live on this node, registering one must not change the replicated state.
+					req.Result <- queue.wait(req.Waiter, req.Deadline)
+					continue
 				}
-			case snapshot <- Queue{
-				ID:              queue.ID,
-				Config:          queue.Config,
-				Messages:        queue.Messages,
-				DeadLetterQueue: queue.DeadLetterQueue}:
+
+				now := req.Now
+				queue.expire(now)
+				queue.releaseInFlight(now)
+				queue.Delayed = queue.releaseDue(queue.Delayed, now)
+				queue.Scheduled = queue.releaseDue(queue.Scheduled, now)
+				queue.forgetDeduplication(now)
+				queue.dropWaiters(now)
+
+				// Dead letters waiting to be moved to the redrive target are handed to the caller of any request.
+				req.Result <- queue.withDeadLetters(queue.handle(req, now))
+			case result := <-snapshot:
+				result <- queue.snapshot()
 			case <-end:
 				return
 			}
@@ -189,3 +348,342 @@ func MakeQueue(id string, config QueueConfig) *QueueIO {
 	}()
 	return &queueIO
 }
+
+// handle runs a request on the queue.
+func (q *Queue) handle(req Request, now time.Time) Response {
+	switch req.Type {
+	case INSERT:
+		return q.insert(req.Message, now)
+	case PEEK:
+		return q.receive(now)
+	case DELETE:
+		return q.remove(req.ReceiptHandle)
+	case REQUEUE:
+		return q.requeue()
+	case CANCEL_SCHEDULED:
+		return q.cancelScheduled(req.MessageID)
+	case DELETE_BY_ID:
+		return q.deleteMessage(req.MessageID)
+	case CHANGE_VISIBILITY:
+		return q.changeVisibility(req.ReceiptHandle, req.VisibilityTimeout, now)
+	case NACK:
+		return q.nack(req.ReceiptHandle, req.Reason, now)
+	case REJECT:
+		return q.reject(req.ReceiptHandle, req.Reason, now)
+	case INSERT_BATCH:
+		return q.insertBatch(req.Messages, now)
+	case PEEK_BATCH:
+		return q.receiveBatch(req.MaxMessages, now)
+	case DELETE_BATCH:
+		return q.removeBatch(req.ReceiptHandles)
+	case INSERT_MOVED:
+		return q.insertMoved(req.Messages, now)
+	case TAKE_DEAD_LETTERS:
+		return q.takeDeadLetters(req.Selector, req.MaxMessages)
+	case TAKE_MESSAGES:
+		return q.takeMessages(req.Selector, req.MaxMessages)
+	case PURGE:
+		return q.purge(req.Purge, now)
+	case GET_CONFIG:
+		return q.getConfig()
+	case UPDATE_CONFIG:
+		return q.updateConfig(req.ConfigUpdate)
+	}
+	return Response{
+		Message: Message{},
+		Code:    OK,
+	}
+}
+
+func (q *Queue) insert(message Message, now time.Time) Response {
+	if uint64(message.Size()) > uint64(q.Config.messageSizeLimit()) {
+		return Response{
+			Message: Message{},
+			Code:    MESSAGE_TOO_LARGE,
+		}
+	}
+	if message.DelaySeconds > MaxDelaySeconds || !q.canSchedule(message, now) || !validAttributes(message.Attributes) {
+		return Response{
+			Message: Message{},
+			Code:    INVALID_MESSAGE,
+		}
+	}
+
+	key := q.deduplicationKey(message)
+	if entry, exists := q.Deduplication[key]; exists && now.Before(entry.ExpiresAt) {
+		return Response{
+			Message: entry.Message,
+			Code:    OK,
+		}
+	}
+
+	if message.TimeStamp.IsZero() {
+		message.TimeStamp = now
+	}
+	// The receive and dead letter fields are kept by the queue, a sender can not set them.
+	message.ReceiveCount = 0
+	message.FirstReceiveTimestamp = time.Time{}
+	message.LastReceiveTimestamp = time.Time{}
+	message.FailureReason = ""
+	message.DeadLetter = nil
+	q.SequenceNumber++
+	message.SequenceNumber = q.SequenceNumber
+	if expiry := message.TimeStamp.Add(q.Config.RetentionPeriod); expiry.Before(q.nextExpiry) {
+		q.nextExpiry = expiry
+	}
+	if message.DeliverAt.After(now) {
+		q.Scheduled = insertDelayed(q.Scheduled, DelayedMessage{
+			Message: message,
+			DueAt:   message.DeliverAt,
+		})
+		q.track(message, messageLocation{state: stateScheduled, dueAt: message.DeliverAt})
+	} else if delay := q.delay(message); delay > 0 {
+		q.Delayed = insertDelayed(q.Delayed, DelayedMessage{
+			Message: message,
+			DueAt:   now.Add(delay),
+		})
+		q.track(message, messageLocation{state: stateDelayed, dueAt: now.Add(delay)})
+	} else {
+		q.ready.push(message)
+		q.track(message, messageLocation{state: stateReady})
+		q.notify()
+	}
+	q.remember(key, message, now)
+	return Response{
+		Message: message,
+		Code:    OK,
+	}
+}
+
+// receive hands out the first visible message and moves it in flight until the visibility timeout passes.
+// Messages whose ReceiveCount has already reached MaxReceiveCount are dead lettered instead.
+// A FIFO queue skips every message group that has a message in flight, so each group is processed
+// one message at a time in order while other groups are still delivered.
+func (q *Queue) receive(now time.Time) Response {
+	blocked := make(map[string]bool)
+	if q.Config.Type == QueueTypeFIFO {
+		for _, inFlight := range q.InFlight {
+			blocked[inFlight.Message.MessageGroupID] = true
+		}
+	}
+
+	skip := func(message Message) bool {
+		return blocked[message.MessageGroupID]
+	}
+	for {
+		message, ok := q.ready.pop(skip)
+		if !ok {
+			break
+		}
+
+		if limit := q.Config.maxReceiveCount(); limit > 0 && message.ReceiveCount >= limit {
+			q.deadLetter(message, DeadLetterMaxReceives, now)
+			continue
+		}
+		message.ReceiveCount++
+		if message.FirstReceiveTimestamp.IsZero() {
+			message.FirstReceiveTimestamp = now
+		}
+		message.LastReceiveTimestamp = now
+
+		q.ReceiveSequence++
+		handle := receiptHandle(q.ID, message.ID, q.ReceiveSequence)
+		q.InFlight[handle] = InFlightMessage{
+			Message:       message,
+			ReceiptHandle: handle,
+			VisibleAt:     now.Add(q.Config.VisibilityTimeout),
+		}
+		q.track(message, messageLocation{state: stateInFlight, receiptHandle: handle})
+		return Response{
+			Message:       message,
+			ReceiptHandle: handle,
+			Code:          OK,
+		}
+	}
+	return Response{
+		Message: Message{},
+		Code:    EMPTY_QUEUE,
+	}
+}
+
+// remove deletes an in flight message. Handles of messages whose visibility timeout has passed are no longer valid.
+func (q *Queue) remove(receiptHandle string) Response {
+	inFlight, exists := q.InFlight[receiptHandle]
+	if !exists {
+		return Response{
+			Message: Message{},
+			Code:    INVALID_RECEIPT_HANDLE,
+		}
+	}
+	delete(q.InFlight, receiptHandle)
+	q.untrack(inFlight.Message)
+	if q.Config.Type == QueueTypeFIFO {
+		q.notify() // the next message of the group is unblocked.
+	}
+	return Response{
+		Message: inFlight.Message,
+		Code:    OK,
+	}
+}
+
+func (q *Queue) requeue() Response {
+	if len(q.DeadLetterQueue) == 0 {
+		return Response{
+			Message: Message{},
+			Code:    EMPTY_DEAD_LETTER_QUEUE,
+		}
+	}
+	message := q.DeadLetterQueue[0]
+	q.DeadLetterQueue = q.DeadLetterQueue[1:]
+	message.ReceiveCount = 0 // a requeued message gets MaxReceiveCount more receives.
+	q.SequenceNumber++
+	message.SequenceNumber = q.SequenceNumber
+	q.ready.push(message)
+	q.track(message, messageLocation{state: stateReady})
+	q.notify()
+	return Response{
+		Message: message,
+		Code:    OK,
+	}
+}
+
+// releaseInFlight makes in flight messages whose visibility timeout has passed visible again.
+// Released messages go back to the front of the queue, since they were received before anything still waiting.
+func (q *Queue) releaseInFlight(now time.Time) {
+	var expired []InFlightMessage
+	for handle, inFlight := range q.InFlight {
+		if now.Before(inFlight.VisibleAt) {
+			continue
+		}
+		expired = append(expired, inFlight)
+		delete(q.InFlight, handle)
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	// Map iteration order is random, sort so every replica rebuilds the same order.
+	sort.Slice(expired, func(i, j int) bool {
+		if !expired[i].VisibleAt.Equal(expired[j].VisibleAt) {
+			return expired[i].VisibleAt.Before(expired[j].VisibleAt)
+		}
+		return expired[i].ReceiptHandle < expired[j].ReceiptHandle
+	})
+	released := make([]Message, 0, len(expired))
+	for _, inFlight := range expired {
+		released = append(released, inFlight.Message)
+		q.track(inFlight.Message, messageLocation{state: stateReady})
+	}
+	q.ready.pushFront(released)
+	q.notify()
+}
+
+// expire drops messages whose TimeStamp is older than the retention period from the queue,
+// the in flight, delayed and scheduled messages and the dead letter queue. A zero retention period keeps messages forever.
+// Queues with a redrive policy dead letter the expired messages instead, except those already in the dead letter queue.
+func (q *Queue) expire(now time.Time) {
+	if q.Config.RetentionPeriod <= 0 || now.Before(q.nextExpiry) {
+		return
+	}
+
+	cutoff := now.Add(-q.Config.RetentionPeriod)
+	oldest := now // the oldest message that is kept decides when the next scan is due.
+	retained := func(message Message) bool {
+		if message.TimeStamp.Before(cutoff) {
+			return false
+		}
+		if message.TimeStamp.Before(oldest) {
+			oldest = message.TimeStamp
+		}
+		return true
+	}
+	// A queue with a redrive policy dead letters expired messages, its target can keep them longer.
+	kept := func(message Message) bool {
+		if retained(message) {
+			return true
+		}
+		q.untrack(message)
+		if q.Config.RedrivePolicy.enabled() {
+			q.deadLetter(message, DeadLetterExpired, now)
+		}
+		return false
+	}
+
+	q.ExpiredMessages += uint64(q.ready.filter(kept))
+
+	var expiredInFlight []Message
+	for handle, inFlight := range q.InFlight {
+		if !retained(inFlight.Message) {
+			delete(q.InFlight, handle)
+			expiredInFlight = append(expiredInFlight, inFlight.Message)
+		}
+	}
+	// Map iteration order is random, sort so every replica dead letters them in the same order.
+	sort.Slice(expiredInFlight, func(i, j int) bool {
+		return expiredInFlight[i].SequenceNumber < expiredInFlight[j].SequenceNumber
+	})
+	_, expired := filterMessages(expiredInFlight, kept)
+	q.ExpiredMessages += uint64(expired)
+	if len(expiredInFlight) > 0 && q.Config.Type == QueueTypeFIFO {
+		q.notify() // the next messages of their groups are unblocked.
+	}
+
+	q.Delayed, expired = filterDelayed(q.Delayed, kept)
+	q.ExpiredMessages += uint64(expired)
+	q.Scheduled, expired = filterDelayed(q.Scheduled, kept)
+	q.ExpiredMessages += uint64(expired)
+
+	q.DeadLetterQueue, expired = filterMessages(q.DeadLetterQueue, retained)
+	q.ExpiredDeadLetters += uint64(expired)
+
+	q.nextExpiry = oldest.Add(q.Config.RetentionPeriod)
+}
+
+// filterMessages keeps the messages accepted by keep in place and returns how many were dropped.
+func filterMessages(messages []Message, keep func(Message) bool) ([]Message, int) {
+	kept := messages[:0]
+	for _, message := range messages {
+		if keep(message) {
+			kept = append(kept, message)
+		}
+	}
+	return kept, len(messages) - len(kept)
+}
+
+func (q *Queue) snapshot() Queue {
+	inFlight := make(map[string]InFlightMessage, len(q.InFlight))
+	for handle, message := range q.InFlight {
+		inFlight[handle] = message
+	}
+	deduplication := make(map[string]DeduplicationEntry, len(q.Deduplication))
+	for key, entry := range q.Deduplication {
+		deduplication[key] = entry
+	}
+	return Queue{
+		ID:              q.ID,
+		Config:          q.Config,
+		Messages:        q.ready.messages(),
+		InFlight:        inFlight,
+		Delayed:         append([]DelayedMessage{}, q.Delayed...),
+		Scheduled:       append([]DelayedMessage{}, q.Scheduled...),
+		DeadLetterQueue: append([]Message{}, q.DeadLetterQueue...),
+
+		PendingDeadLetters: append([]Message{}, q.PendingDeadLetters...),
+		Deduplication:      deduplication,
+
+		ExpiredMessages:    q.ExpiredMessages,
+		ExpiredDeadLetters: q.ExpiredDeadLetters,
+		SequenceNumber:     q.SequenceNumber,
+		ReceiveSequence:    q.ReceiveSequence,
+
+		LastPurgedAt: q.LastPurgedAt,
+	}
+}
+
+// receiptHandle derives the handle for one receive of a message. It only depends on replicated state,
+// so every replica hands out the same handle for the same receive. The receive count of a message starts
+// over when it is requeued or redriven, the queue's receive sequence never does.
+func receiptHandle(queueID, messageID string, receiveSequence uint64) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/r%d", queueID, messageID, receiveSequence)))
+	return hex.EncodeToString(sum[:16])
+}
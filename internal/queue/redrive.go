@@ -0,0 +1,144 @@
+package queue
+
+import "time"
+
+// RedrivePolicy moves messages that were received MaxReceiveCount times without being deleted
+// to another queue, where they can be received, monitored and configured like any other message.
+// Queues without a policy keep such messages in their own DeadLetterQueue.
+type RedrivePolicy struct {
+	DeadLetterTargetQueue string
+	MaxReceiveCount       uint16
+}
+
+func (p RedrivePolicy) enabled() bool {
+	return p.DeadLetterTargetQueue != ""
+}
+
+// InsertMoved adds messages moved from another queue by a redrive policy or a redrive task. Unlike
+// InsertQueue it does not validate or deduplicate them, they were accepted by the other queue already.
+func (q *QueueIO) InsertMoved(now time.Time, messages []Message) Response {
+	response := make(chan Response)
+	q.SendChan <- Request{
+		Type:     INSERT_MOVED,
+		Now:      now,
+		Messages: messages,
+		Result:   response,
+	}
+	return <-response
+}
+
+// insertMoved makes moved messages ready right away. They keep their TimeStamp, so the retention
+// period still counts from the original send, and start over with no receives in this queue.
+// Messages larger than the queue accepts are dead lettered as invalid.
+func (q *Queue) insertMoved(messages []Message, now time.Time) Response {
+	for _, message := range messages {
+		message.ReceiveCount = 0
+		if uint64(message.Size()) > uint64(q.Config.messageSizeLimit()) {
+			q.deadLetter(message, DeadLetterInvalid, now)
+			continue
+		}
+		q.SequenceNumber++
+		message.SequenceNumber = q.SequenceNumber
+		if expiry := message.TimeStamp.Add(q.Config.RetentionPeriod); expiry.Before(q.nextExpiry) {
+			q.nextExpiry = expiry
+		}
+		q.ready.push(message)
+		q.track(message, messageLocation{state: stateReady})
+	}
+	if len(messages) > 0 {
+		q.notify()
+	}
+	return Response{
+		Message: Message{},
+		Code:    OK,
+	}
+}
+
+// MessageSelector picks the messages a redrive task moves. Zero fields match every message.
+type MessageSelector struct {
+	MessageIDs []string  `json:"message_ids,omitempty"`
+	SentAfter  time.Time `json:"sent_after,omitempty"`  // only messages sent at or after this time.
+	SentBefore time.Time `json:"sent_before,omitempty"` // only messages sent before this time.
+}
+
+func (s MessageSelector) matches(message Message) bool {
+	if !s.SentAfter.IsZero() && message.TimeStamp.Before(s.SentAfter) {
+		return false
+	}
+	if !s.SentBefore.IsZero() && !message.TimeStamp.Before(s.SentBefore) {
+		return false
+	}
+	if len(s.MessageIDs) == 0 {
+		return true
+	}
+	for _, id := range s.MessageIDs {
+		if id == message.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// TakeDeadLetters removes up to maxMessages messages matching the selector from the DeadLetterQueue,
+// oldest first, and returns them in Batch.
+func (q *QueueIO) TakeDeadLetters(now time.Time, selector MessageSelector, maxMessages int) Response {
+	response := make(chan Response)
+	q.SendChan <- Request{
+		Type:        TAKE_DEAD_LETTERS,
+		Now:         now,
+		Selector:    selector,
+		MaxMessages: maxMessages,
+		Result:      response,
+	}
+	return <-response
+}
+
+// TakeMessages removes up to maxMessages ready messages matching the selector in delivery order and
+// returns them in Batch. It is used to move the dead letters out of a queue that is a redrive target.
+func (q *QueueIO) TakeMessages(now time.Time, selector MessageSelector, maxMessages int) Response {
+	response := make(chan Response)
+	q.SendChan <- Request{
+		Type:        TAKE_MESSAGES,
+		Now:         now,
+		Selector:    selector,
+		MaxMessages: maxMessages,
+		Result:      response,
+	}
+	return <-response
+}
+
+func (q *Queue) takeDeadLetters(selector MessageSelector, maxMessages int) Response {
+	var taken []Response
+	q.DeadLetterQueue, _ = filterMessages(q.DeadLetterQueue, func(message Message) bool {
+		if len(taken) < maxMessages && selector.matches(message) {
+			taken = append(taken, Response{Message: message, Code: OK})
+			return false
+		}
+		return true
+	})
+	return Response{
+		Message: Message{},
+		Code:    OK,
+		Batch:   taken,
+	}
+}
+
+func (q *Queue) takeMessages(selector MessageSelector, maxMessages int) Response {
+	var taken []Response
+	skip := func(message Message) bool {
+		return !selector.matches(message)
+	}
+	for len(taken) < maxMessages {
+		message, ok := q.ready.pop(skip)
+		if !ok {
+			break
+		}
+		q.untrack(message)
+		taken = append(taken, Response{Message: message, Code: OK})
+	}
+	return Response{
+		Message: Message{},
+		Code:    OK,
+		Batch:   taken,
+	}
+}
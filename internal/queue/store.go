@@ -0,0 +1,244 @@
+package queue
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/Weile-Zheng/simplyQ/internal/deque"
+)
+
+// messageStore holds the messages that are ready to be received, in the order they are delivered.
+type messageStore interface {
+	push(message Message)                        // adds a message that became ready.
+	pushFront(messages []Message)                // returns released messages ahead of the messages still waiting.
+	pop(skip func(Message) bool) (Message, bool) // removes the first message in delivery order that is not skipped.
+	filter(keep func(Message) bool) int          // drops the messages not accepted by keep and returns how many.
+	remove(sequenceNumber uint64)                // deletes a message wherever it is in the store.
+	len() int
+	messages() []Message // copies the messages in delivery order.
+}
+
+// newMessageStore returns the store for a queue type, filled with messages in delivery order.
+func newMessageStore(queueType QueueType, messages []Message) messageStore {
+	if queueType == QueueTypePriority {
+		store := &messageHeap{order: append(priorityOrder{}, messages...), removed: removedSet{}}
+		heap.Init(&store.order)
+		return store
+	}
+	store := &messageList{removed: removedSet{}}
+	for _, message := range messages {
+		store.push(message)
+	}
+	return store
+}
+
+// removedSet holds the sequence numbers of messages deleted from the middle of a store. They stay in the
+// store, bodies included, until they reach the front or the store is filtered, so a delete does not
+// have to find them.
+type removedSet map[uint64]struct{}
+
+func (r removedSet) has(message Message) bool {
+	_, removed := r[message.SequenceNumber]
+	return removed
+}
+
+// without wraps keep to also drop the removed messages, a nil keep keeps every other message.
+func (r removedSet) without(keep func(Message) bool) func(Message) bool {
+	return func(message Message) bool {
+		if r.has(message) {
+			return false
+		}
+		return keep == nil || keep(message)
+	}
+}
+
+// messageList delivers messages in the order they became ready, used by standard and FIFO queues.
+// The messages are kept in a chunked deque, so memory is released as the queue drains. A message
+// received from behind the front, which FIFO queues do when the first groups are blocked, leaves a
+// hole that is dropped once it reaches the front.
+//
+// Pushing, popping the front and deleting are O(1), but pop scans past the holes, the deleted messages
+// and the messages of blocked groups. A FIFO queue whose first group is stuck in flight pays for every
+// message of that group on each receive, and a long run of holes behind it keeps its chunks alive.
+type messageList struct {
+	list    deque.Deque[listEntry]
+	holes   int
+	removed removedSet
+}
+
+type listEntry struct {
+	message  Message
+	received bool // the message was received from the middle of the list, the entry is a hole.
+}
+
+func (l *messageList) push(message Message) {
+	l.list.PushBack(listEntry{message: message})
+}
+
+func (l *messageList) pushFront(messages []Message) {
+	for i := len(messages) - 1; i >= 0; i-- {
+		l.list.PushFront(listEntry{message: messages[i]})
+	}
+}
+
+func (l *messageList) pop(skip func(Message) bool) (Message, bool) {
+	l.trim()
+	for i := 0; i < l.list.Len(); i++ {
+		entry := l.list.At(i)
+		if entry.received || l.removed.has(entry.message) || skip(entry.message) {
+			continue
+		}
+		if i == 0 {
+			l.list.PopFront()
+			l.trim()
+		} else {
+			l.list.Set(i, listEntry{received: true})
+			l.holes++
+		}
+		return entry.message, true
+	}
+	return Message{}, false
+}
+
+// trim drops the holes and removed messages at the front.
+func (l *messageList) trim() {
+	for l.list.Len() > 0 {
+		front := l.list.Front()
+		switch {
+		case front.received:
+			l.holes--
+		case l.removed.has(front.message):
+			delete(l.removed, front.message.SequenceNumber)
+		default:
+			return
+		}
+		l.list.PopFront()
+	}
+}
+
+func (l *messageList) filter(keep func(Message) bool) int {
+	var kept deque.Deque[listEntry]
+	dropped := 0
+	for l.list.Len() > 0 {
+		entry := l.list.PopFront()
+		if entry.received || l.removed.has(entry.message) {
+			continue
+		}
+		if keep(entry.message) {
+			kept.PushBack(entry)
+		} else {
+			dropped++
+		}
+	}
+	l.list = kept
+	l.holes = 0
+	l.removed = removedSet{}
+	return dropped
+}
+
+func (l *messageList) remove(sequenceNumber uint64) {
+	l.removed[sequenceNumber] = struct{}{}
+}
+
+func (l *messageList) len() int {
+	return l.list.Len() - l.holes - len(l.removed)
+}
+
+func (l *messageList) messages() []Message {
+	messages := make([]Message, 0, l.len())
+	for i := 0; i < l.list.Len(); i++ {
+		if entry := l.list.At(i); !entry.received && !l.removed.has(entry.message) {
+			messages = append(messages, entry.message)
+		}
+	}
+	return messages
+}
+
+// messageHeap delivers the message with the highest Priority first, and messages of equal
+// priority in the order they were sent. Used by priority queues.
+type messageHeap struct {
+	order   priorityOrder
+	removed removedSet
+}
+
+func (h *messageHeap) push(message Message) {
+	heap.Push(&h.order, message)
+}
+
+// pushFront pushes released messages like any other, their SequenceNumber already puts them
+// ahead of the messages of equal priority that were sent after them.
+func (h *messageHeap) pushFront(messages []Message) {
+	for _, message := range messages {
+		heap.Push(&h.order, message)
+	}
+}
+
+func (h *messageHeap) pop(skip func(Message) bool) (Message, bool) {
+	var skipped []Message
+	defer func() {
+		for _, message := range skipped {
+			heap.Push(&h.order, message)
+		}
+	}()
+	for h.order.Len() > 0 {
+		message := heap.Pop(&h.order).(Message)
+		if h.removed.has(message) {
+			delete(h.removed, message.SequenceNumber)
+			continue
+		}
+		if skip(message) {
+			skipped = append(skipped, message)
+			continue
+		}
+		return message, true
+	}
+	return Message{}, false
+}
+
+func (h *messageHeap) filter(keep func(Message) bool) int {
+	var dropped int
+	h.order, dropped = filterMessages(h.order, h.removed.without(keep))
+	if dropped > 0 {
+		heap.Init(&h.order)
+	}
+	dropped -= len(h.removed)
+	h.removed = removedSet{}
+	return dropped
+}
+
+func (h *messageHeap) remove(sequenceNumber uint64) {
+	h.removed[sequenceNumber] = struct{}{}
+}
+
+func (h *messageHeap) len() int {
+	return h.order.Len() - len(h.removed)
+}
+
+func (h *messageHeap) messages() []Message {
+	messages, _ := filterMessages(append(priorityOrder{}, h.order...), h.removed.without(nil))
+	sort.Sort(priorityOrder(messages))
+	return messages
+}
+
+// priorityOrder implements heap.Interface, ordering messages by descending Priority and then by SequenceNumber.
+type priorityOrder []Message
+
+func (o priorityOrder) Len() int { return len(o) }
+
+func (o priorityOrder) Less(i, j int) bool {
+	if o[i].Priority != o[j].Priority {
+		return o[i].Priority > o[j].Priority
+	}
+	return o[i].SequenceNumber < o[j].SequenceNumber
+}
+
+func (o priorityOrder) Swap(i, j int) { o[i], o[j] = o[j], o[i] }
+
+func (o *priorityOrder) Push(x any) { *o = append(*o, x.(Message)) }
+
+func (o *priorityOrder) Pop() any {
+	old := *o
+	message := old[len(old)-1]
+	*o = old[:len(old)-1]
+	return message
+}
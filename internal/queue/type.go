@@ -1,10 +1,16 @@
 package queue
 
+// QueueType decides how messages are handed out.
+// Standard queues let many messages be in flight at once and only order them on a best effort basis.
+// FIFO queues deliver strictly in order within a message group, the next message of a group is only
+// handed out once the one in flight is deleted. Different groups are delivered in parallel.
+// Priority queues deliver the visible message with the highest Priority first, in send order among equal priorities.
 type QueueType string
 
 const (
 	QueueTypeStandard QueueType = "standard"
 	QueueTypeFIFO     QueueType = "fifo"
+	QueueTypePriority QueueType = "priority"
 )
 
 type opType int
@@ -14,6 +20,24 @@ const (
 	PEEK
 	DELETE
 	REQUEUE
+	CANCEL_SCHEDULED
+	DELETE_BY_ID
+	CHANGE_VISIBILITY
+	NACK
+	REJECT
+
+	INSERT_BATCH
+	PEEK_BATCH
+	DELETE_BATCH
+
+	INSERT_MOVED
+	TAKE_DEAD_LETTERS
+	TAKE_MESSAGES
+
+	PURGE
+	TICK
+
+	WAIT
 
 	GET_CONFIG
 	UPDATE_CONFIG
@@ -27,4 +51,21 @@ const (
 	EMPTY_DEAD_LETTER_QUEUE
 	QUEUE_NOT_FOUND
 	QUEUE_ALREADY_EXISTS
+	INVALID_RECEIPT_HANDLE
+	MESSAGE_TOO_LARGE
+	INVALID_QUEUE_CONFIG
+	INVALID_MESSAGE
+	MESSAGE_NOT_FOUND
+	INVALID_BATCH_SIZE
+	INVALID_VISIBILITY_TIMEOUT
+	DEAD_LETTER_TARGET_NOT_FOUND
+	QUEUE_IN_USE
+	INVALID_REDRIVE_TASK
+	REDRIVE_TASK_NOT_FOUND
+	INVALID_PURGE_TARGET
+	PURGE_IN_PROGRESS
 )
+
+// MaxMessageSizeLimit is the cluster wide hard maximum of a message body and its attributes in bytes.
+// It caps QueueConfig.MaxMessageSize and is checked before a message is written to the Raft log.
+const MaxMessageSizeLimit uint32 = 256 * 1024
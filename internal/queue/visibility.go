@@ -0,0 +1,56 @@
+package queue
+
+import "time"
+
+// MaxVisibilityTimeout is the longest a received message can be hidden from other consumers.
+const MaxVisibilityTimeout = 12 * time.Hour
+
+// ChangeVisibility hides the in flight message identified by the receipt handle for timeout from now,
+// extending or shortening its visibility timeout. A zero timeout releases the message right away.
+func (q *QueueIO) ChangeVisibility(now time.Time, receiptHandle string, timeout time.Duration) Response {
+	response := make(chan Response)
+	q.SendChan <- Request{
+		Type:              CHANGE_VISIBILITY,
+		Now:               now,
+		ReceiptHandle:     receiptHandle,
+		VisibilityTimeout: timeout,
+		Result:            response,
+	}
+	return <-response
+}
+
+// changeVisibility moves the VisibleAt of an in flight message. The receipt handle stays valid until the
+// new timeout passes, except for a zero timeout, which puts the message back at the front of the queue.
+func (q *Queue) changeVisibility(receiptHandle string, timeout time.Duration, now time.Time) Response {
+	if timeout < 0 || timeout > MaxVisibilityTimeout {
+		return Response{
+			Message: Message{},
+			Code:    INVALID_VISIBILITY_TIMEOUT,
+		}
+	}
+	inFlight, exists := q.InFlight[receiptHandle]
+	if !exists {
+		return Response{
+			Message: Message{},
+			Code:    INVALID_RECEIPT_HANDLE,
+		}
+	}
+
+	if timeout == 0 {
+		delete(q.InFlight, receiptHandle)
+		q.ready.pushFront([]Message{inFlight.Message})
+		q.track(inFlight.Message, messageLocation{state: stateReady})
+		q.notify()
+		return Response{
+			Message: inFlight.Message,
+			Code:    OK,
+		}
+	}
+	inFlight.VisibleAt = now.Add(timeout)
+	q.InFlight[receiptHandle] = inFlight
+	return Response{
+		Message:       inFlight.Message,
+		ReceiptHandle: receiptHandle,
+		Code:          OK,
+	}
+}
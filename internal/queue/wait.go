@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"time"
+)
+
+// waiter is a blocked receiver that is woken when a message may have become ready.
+type waiter struct {
+	wake     chan struct{}
+	deadline time.Time
+}
+
+// WaitForMessage registers a waiter with the queue and returns a channel that is closed as soon as a message
+// is sent, released from a delay or made visible again. It does not receive the message, so callers that go
+// through Raft try their receive again once woken. Waiters are forgotten after the deadline.
+func (q *QueueIO) WaitForMessage(deadline time.Time) <-chan struct{} {
+	wake := make(chan struct{})
+	response := make(chan Response)
+	q.SendChan <- Request{
+		Type:     WAIT,
+		Waiter:   wake,
+		Deadline: deadline,
+		Result:   response,
+	}
+	<-response
+	return wake
+}
+
+func (q *Queue) wait(wake chan struct{}, deadline time.Time) Response {
+	q.waiters = append(q.waiters, waiter{
+		wake:     wake,
+		deadline: deadline,
+	})
+	return Response{
+		Message: Message{},
+		Code:    OK,
+	}
+}
+
+// notify wakes every waiting receiver.
+func (q *Queue) notify() {
+	for _, waiter := range q.waiters {
+		close(waiter.wake)
+	}
+	q.waiters = nil
+}
+
+// dropWaiters forgets waiters whose deadline has passed, their receivers have stopped waiting.
+func (q *Queue) dropWaiters(now time.Time) {
+	kept := q.waiters[:0]
+	for _, waiter := range q.waiters {
+		if now.Before(waiter.deadline) {
+			kept = append(kept, waiter)
+		}
+	}
+	q.waiters = kept
+}
@@ -4,7 +4,9 @@ package queue_manager
 
 import (
 	"log"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/Weile-Zheng/simplyQ/internal/queue"
 )
@@ -12,6 +14,9 @@ import (
 type QueueManager struct {
 	Queues map[string]*queue.QueueIO
 	Lock   sync.RWMutex
+
+	redriveTargets map[string]string // the dead letter target of every queue with a redrive policy, keyed by queue ID.
+	redriveTasks   []RedriveTask     // redrive tasks in the order they were started, including finished ones.
 }
 
 type QueueManagerConfig struct {
@@ -23,6 +28,8 @@ func NewQueueManager(config QueueManagerConfig) QueueManager {
 	return QueueManager{
 		Queues: make(map[string]*queue.QueueIO),
 		Lock:   sync.RWMutex{},
+
+		redriveTargets: make(map[string]string),
 	}
 }
 
@@ -37,70 +44,362 @@ func (qm *QueueManager) CreateQueue(config queue.QueueConfig) queue.Code {
 		return queue.QUEUE_ALREADY_EXISTS
 	}
 
+	if code := config.Validate(); code != queue.OK {
+		return code
+	}
+
+	if target := config.RedrivePolicy.DeadLetterTargetQueue; target != "" {
+		if _, exists := qm.Queues[target]; !exists {
+			return queue.DEAD_LETTER_TARGET_NOT_FOUND
+		}
+		qm.redriveTargets[id] = target
+	}
+
 	// Assuming queueIO has a Name field to identify the queue
 	qm.Queues[id] = queue.MakeQueue(id, config)
 	return queue.OK
 }
 
-// DeleteQueue removes a queue by its ID.
+// DeleteQueue removes a queue by its ID. A queue that is the dead letter target of another queue can not be deleted.
 func (qm *QueueManager) DeleteQueue(id string) queue.Code {
 	qm.Lock.Lock()
 	defer qm.Lock.Unlock()
 
 	if q, exists := qm.Queues[id]; exists {
+		if len(qm.sourceQueues(id)) > 0 {
+			return queue.QUEUE_IN_USE
+		}
 		q.Close()
 		delete(qm.Queues, id)
+		delete(qm.redriveTargets, id)
 		return queue.OK
 	}
 	return queue.QUEUE_NOT_FOUND
 }
 
+// GetQueueConfig returns the configuration of the specified queue.
+func (qm *QueueManager) GetQueueConfig(now time.Time, queueID string) ConfigView {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	if q, exists := qm.Queues[queueID]; exists {
+		response := qm.moveDeadLetters(now, queueID, q.GetConfig(now))
+		return ConfigView{Code: response.Code, QueueConfig: response.Config}
+	}
+	return ConfigView{Code: queue.QUEUE_NOT_FOUND}
+}
+
+// UpdateQueueConfig changes the configuration of a live queue without recreating it, fields the update
+// leaves out keep their value. The Name and Type can not change. A new redrive policy must point to an
+// existing queue that does not dead letter back to this one, directly or through other queues.
+func (qm *QueueManager) UpdateQueueConfig(now time.Time, queueID string, update queue.ConfigUpdate) ConfigView {
+	qm.Lock.Lock()
+	defer qm.Lock.Unlock()
+
+	q, exists := qm.Queues[queueID]
+	if !exists {
+		return ConfigView{Code: queue.QUEUE_NOT_FOUND}
+	}
+
+	if policy := update.RedrivePolicy; policy != nil && policy.DeadLetterTargetQueue != "" {
+		target := policy.DeadLetterTargetQueue
+		if _, exists := qm.Queues[target]; !exists {
+			return ConfigView{Code: queue.DEAD_LETTER_TARGET_NOT_FOUND}
+		}
+		if qm.redrivesTo(target, queueID) {
+			return ConfigView{Code: queue.INVALID_QUEUE_CONFIG}
+		}
+	}
+
+	response := qm.moveDeadLetters(now, queueID, q.UpdateConfig(now, update))
+	if response.Code != queue.OK {
+		return ConfigView{Code: response.Code}
+	}
+	if target := response.Config.RedrivePolicy.DeadLetterTargetQueue; target != "" {
+		qm.redriveTargets[queueID] = target
+	} else {
+		delete(qm.redriveTargets, queueID)
+	}
+	return ConfigView{Code: queue.OK, QueueConfig: response.Config}
+}
+
+// redrivesTo reports whether dead letters of the source end up in the target, following the redrive
+// policies from queue to queue. The policies never form a cycle, so the walk ends.
+func (qm *QueueManager) redrivesTo(source string, target string) bool {
+	for id := source; id != ""; id = qm.redriveTargets[id] {
+		if id == target {
+			return true
+		}
+	}
+	return false
+}
+
 // We use R lock for send, peek, delete message to allow parallel operations on different queues
 // Concurrent operations within the same queues are handled next level down by the queue itself.
 
 // SendMessage sends a message to the specified queue.
-func (qm *QueueManager) SendMessage(queueID string, message queue.Message) queue.Response {
+func (qm *QueueManager) SendMessage(now time.Time, queueID string, message queue.Message) queue.Response {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	if q, exists := qm.Queues[queueID]; exists {
+		return qm.moveDeadLetters(now, queueID, q.InsertQueue(now, message))
+	}
+	return queue.Response{Code: queue.QUEUE_NOT_FOUND, Message: queue.Message{}}
+}
+
+// PeekMessage receives the next visible message from the specified queue, hiding it for the visibility timeout.
+func (qm *QueueManager) PeekMessage(now time.Time, queueID string) queue.Response {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	if q, exists := qm.Queues[queueID]; exists {
+		return qm.moveDeadLetters(now, queueID, q.PeekQueue(now))
+	}
+	return queue.Response{Code: queue.QUEUE_NOT_FOUND, Message: queue.Message{}}
+}
+
+// PopMessage deletes the in flight message identified by the receipt handle from the specified queue.
+func (qm *QueueManager) PopMessage(now time.Time, queueID string, receiptHandle string) queue.Response {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	if q, exists := qm.Queues[queueID]; exists {
+		return qm.moveDeadLetters(now, queueID, q.RemoveQueue(now, receiptHandle))
+	}
+	return queue.Response{Code: queue.QUEUE_NOT_FOUND, Message: queue.Message{}}
+}
+
+// DeleteMessage deletes a message from the specified queue by its ID, wherever it is in the queue.
+// Unlike PopMessage it does not need the message to be received first.
+func (qm *QueueManager) DeleteMessage(now time.Time, queueID string, messageID string) queue.Response {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	if q, exists := qm.Queues[queueID]; exists {
+		return qm.moveDeadLetters(now, queueID, q.DeleteMessage(now, messageID))
+	}
+	return queue.Response{Code: queue.QUEUE_NOT_FOUND, Message: queue.Message{}}
+}
+
+// ChangeMessageVisibility changes how long the in flight message identified by the receipt handle stays hidden.
+// A zero timeout makes it visible again right away.
+func (qm *QueueManager) ChangeMessageVisibility(now time.Time, queueID string, receiptHandle string, timeout time.Duration) queue.Response {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	if q, exists := qm.Queues[queueID]; exists {
+		return qm.moveDeadLetters(now, queueID, q.ChangeVisibility(now, receiptHandle, timeout))
+	}
+	return queue.Response{Code: queue.QUEUE_NOT_FOUND, Message: queue.Message{}}
+}
+
+// NackMessage makes the in flight message identified by the receipt handle visible again right away,
+// storing the reason the consumer gives for failing to process it on the message.
+func (qm *QueueManager) NackMessage(now time.Time, queueID string, receiptHandle string, reason string) queue.Response {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	if q, exists := qm.Queues[queueID]; exists {
+		return qm.moveDeadLetters(now, queueID, q.NackMessage(now, receiptHandle, reason))
+	}
+	return queue.Response{Code: queue.QUEUE_NOT_FOUND, Message: queue.Message{}}
+}
+
+// RejectMessage dead letters the in flight message identified by the receipt handle right away, with the consumer's reason.
+func (qm *QueueManager) RejectMessage(now time.Time, queueID string, receiptHandle string, reason string) queue.Response {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	if q, exists := qm.Queues[queueID]; exists {
+		return qm.moveDeadLetters(now, queueID, q.RejectMessage(now, receiptHandle, reason))
+	}
+	return queue.Response{Code: queue.QUEUE_NOT_FOUND, Message: queue.Message{}}
+}
+
+// SendMessageBatch sends several messages to the specified queue in one request, with a result for every message.
+func (qm *QueueManager) SendMessageBatch(now time.Time, queueID string, messages []queue.Message) queue.Response {
 	qm.Lock.RLock()
 	defer qm.Lock.RUnlock()
 
 	if q, exists := qm.Queues[queueID]; exists {
-		return q.InsertQueue(message)
+		return qm.moveDeadLetters(now, queueID, q.InsertQueueBatch(now, messages))
 	}
 	return queue.Response{Code: queue.QUEUE_NOT_FOUND, Message: queue.Message{}}
 }
 
-// PeekMessage retrieves the next message from the specified queue without removing it.
-func (qm *QueueManager) PeekMessage(queueID string) queue.Response {
+// ReceiveMessageBatch receives up to maxMessages visible messages from the specified queue.
+func (qm *QueueManager) ReceiveMessageBatch(now time.Time, queueID string, maxMessages int) queue.Response {
 	qm.Lock.RLock()
 	defer qm.Lock.RUnlock()
 
 	if q, exists := qm.Queues[queueID]; exists {
-		return q.PeekQueue()
+		return qm.moveDeadLetters(now, queueID, q.PeekQueueBatch(now, maxMessages))
 	}
 	return queue.Response{Code: queue.QUEUE_NOT_FOUND, Message: queue.Message{}}
 }
 
-// PopMessage removes a message from the specified queue.
-func (qm *QueueManager) PopMessage(queueID string) queue.Response {
+// DeleteMessageBatch deletes several in flight messages from the specified queue, with a result for every receipt handle.
+func (qm *QueueManager) DeleteMessageBatch(now time.Time, queueID string, receiptHandles []string) queue.Response {
 	qm.Lock.RLock()
 	defer qm.Lock.RUnlock()
 
 	if q, exists := qm.Queues[queueID]; exists {
-		return q.RemoveQueue()
+		return qm.moveDeadLetters(now, queueID, q.RemoveQueueBatch(now, receiptHandles))
 	}
 	return queue.Response{Code: queue.QUEUE_NOT_FOUND, Message: queue.Message{}}
 }
 
+// moveDeadLetters moves the messages a request dead lettered to the redrive target of the queue, and on
+// to the target's own target if it dead letters them too. It is called while the lock is held,
+// so no target can be deleted in between.
+func (qm *QueueManager) moveDeadLetters(now time.Time, queueID string, response queue.Response) queue.Response {
+	messages := response.DeadLetters
+	response.DeadLetters = nil
+	for len(messages) > 0 {
+		targetID := qm.redriveTargets[queueID]
+		target, exists := qm.Queues[targetID]
+		if !exists {
+			log.Printf("Dead letter target of queue %s not found, dropping %d messages", queueID, len(messages))
+			break
+		}
+		queueID, messages = targetID, target.InsertMoved(now, messages).DeadLetters
+	}
+	return response
+}
+
+// PurgeQueue removes the messages of the specified queue selected by target, keeping its configuration.
+func (qm *QueueManager) PurgeQueue(now time.Time, queueID string, target queue.PurgeTarget) PurgeView {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	if q, exists := qm.Queues[queueID]; exists {
+		response := qm.moveDeadLetters(now, queueID, q.PurgeQueue(now, target))
+		return PurgeView{
+			Code:   response.Code,
+			Purged: response.Purged,
+		}
+	}
+	return PurgeView{Code: queue.QUEUE_NOT_FOUND}
+}
+
+// Tick applies the time driven transitions that are due at now to the given queues, in order, or to every
+// queue in ID order when queueIDs is empty. Messages the queues dead letter are moved to their redrive targets.
+// Queues deleted since the leader listed them are skipped.
+func (qm *QueueManager) Tick(now time.Time, queueIDs []string) queue.Code {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	if len(queueIDs) == 0 {
+		queueIDs = qm.queueIDs()
+	}
+	for _, queueID := range queueIDs {
+		if q, exists := qm.Queues[queueID]; exists {
+			qm.moveDeadLetters(now, queueID, q.Tick(now))
+		}
+	}
+	return queue.OK
+}
+
+// QueueIDs returns the IDs of all queues in sorted order.
+func (qm *QueueManager) QueueIDs() []string {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	return qm.queueIDs()
+}
+
+func (qm *QueueManager) queueIDs() []string {
+	ids := make([]string, 0, len(qm.Queues))
+	for id := range qm.Queues {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ListSourceQueues returns the queues whose redrive policy moves dead letters to the specified queue.
+func (qm *QueueManager) ListSourceQueues(queueID string) SourceQueuesView {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	if _, exists := qm.Queues[queueID]; exists {
+		return SourceQueuesView{
+			Code:         queue.OK,
+			SourceQueues: qm.sourceQueues(queueID),
+		}
+	}
+	return SourceQueuesView{Code: queue.QUEUE_NOT_FOUND}
+}
+
+// sourceQueues returns the sorted IDs of the queues that dead letter to the target.
+func (qm *QueueManager) sourceQueues(target string) []string {
+	sources := []string{}
+	for source, sourceTarget := range qm.redriveTargets {
+		if sourceTarget == target {
+			sources = append(sources, source)
+		}
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// WaitForMessage returns a channel that is closed once a message may be ready in the specified queue,
+// or nil if the queue does not exist. The lock is only held to register the waiter, callers wait on the
+// channel after it is released.
+func (qm *QueueManager) WaitForMessage(queueID string, deadline time.Time) <-chan struct{} {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	if q, exists := qm.Queues[queueID]; exists {
+		return q.WaitForMessage(deadline)
+	}
+	return nil
+}
+
 // ViewAllMessages printout all messages from the specified queue (does not remove them or call peek/receive).
-func (qm *QueueManager) ViewAllMessages(queueID string) []queue.Message {
+func (qm *QueueManager) ViewAllMessages(queueID string) QueueView {
 	qm.Lock.RLock()
 	defer qm.Lock.RUnlock()
 
 	if q, exists := qm.Queues[queueID]; exists {
-		return q.SnapshotQueue().Messages
+		snapshot := q.SnapshotQueue()
+		return QueueView{
+			Code:      queue.OK,
+			Messages:  snapshot.Messages,
+			Delayed:   snapshot.Delayed,
+			Scheduled: snapshot.Scheduled,
+
+			DeadLetters: snapshot.DeadLetterQueue,
+		}
 	}
 	log.Printf("Queue %s not found", queueID)
-	return nil
+	return QueueView{Code: queue.QUEUE_NOT_FOUND}
+}
+
+// ListScheduledMessages returns the messages of the specified queue that wait for their DeliverAt.
+func (qm *QueueManager) ListScheduledMessages(queueID string) ScheduledView {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	if q, exists := qm.Queues[queueID]; exists {
+		return ScheduledView{
+			Code:      queue.OK,
+			Scheduled: q.SnapshotQueue().Scheduled,
+		}
+	}
+	return ScheduledView{Code: queue.QUEUE_NOT_FOUND}
+}
+
+// CancelScheduledMessage removes a scheduled message from the specified queue before it is delivered.
+func (qm *QueueManager) CancelScheduledMessage(now time.Time, queueID string, messageID string) queue.Response {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	if q, exists := qm.Queues[queueID]; exists {
+		return qm.moveDeadLetters(now, queueID, q.CancelScheduled(now, messageID))
+	}
+	return queue.Response{Code: queue.QUEUE_NOT_FOUND, Message: queue.Message{}}
 }
 
 // ViewAllQueues returns a snapshot of all queues and their messages.
@@ -115,16 +414,25 @@ func (qm *QueueManager) ViewAllQueues() map[string]queue.Queue {
 	return queuesSnapshot
 }
 
+// RestoreAllQueues replaces all queues with the given snapshots, including in flight messages,
+// dead letters and deduplication entries.
 func (qm *QueueManager) RestoreAllQueues(queues map[string]queue.Queue) {
 	qm.Lock.Lock()
 	defer qm.Lock.Unlock()
 
 	restored_queues := make(map[string]*queue.QueueIO)
+	redriveTargets := make(map[string]string)
 
 	for id := range queues {
-		restored_queues[id] = queue.MakeQueue(id, queues[id].Config)
-		for _, msg := range queues[id].Messages {
-			restored_queues[id].InsertQueue(msg)
+		restored_queues[id] = queue.RestoreQueue(queues[id])
+		if target := queues[id].Config.RedrivePolicy.DeadLetterTargetQueue; target != "" {
+			redriveTargets[id] = target
 		}
 	}
+
+	for _, q := range qm.Queues {
+		q.Close()
+	}
+	qm.Queues = restored_queues
+	qm.redriveTargets = redriveTargets
 }
@@ -0,0 +1,184 @@
+package queue_manager
+
+import (
+	"time"
+
+	"github.com/Weile-Zheng/simplyQ/internal/queue"
+)
+
+const (
+	DefaultRedriveRate = 10   // messages a redrive task moves per second when no rate is given.
+	MaxRedriveRate     = 1000 // the highest rate a redrive task can be started with.
+)
+
+type RedriveTaskStatus string
+
+const (
+	RedriveRunning   RedriveTaskStatus = "running"
+	RedriveCompleted RedriveTaskStatus = "completed"
+	RedriveCancelled RedriveTaskStatus = "cancelled"
+	RedriveFailed    RedriveTaskStatus = "failed" // a queue of the task was deleted while it was running.
+)
+
+// RedriveTask moves dead letters back to a queue that can process them, at most MaxMessagesPerSecond
+// at a time. The leader steps every running task once a second through Raft, so its progress is
+// replicated and a new leader picks up where the old one stopped.
+//
+// DeadLetterQueue is either a queue that keeps dead letters in its own DeadLetterQueue, or a queue
+// that is the redrive target of other queues, whose ready messages are all dead letters.
+// DestinationQueue defaults to the queue the dead letters came from: the queue itself in the first case,
+// and the only source queue in the second.
+type RedriveTask struct {
+	ID                   string                `json:"id"`
+	DeadLetterQueue      string                `json:"dead_letter_queue"`
+	DestinationQueue     string                `json:"destination_queue,omitempty"`
+	Selector             queue.MessageSelector `json:"selector"`
+	MaxMessagesPerSecond int                   `json:"max_messages_per_second,omitempty"`
+
+	Status        RedriveTaskStatus `json:"status"`
+	MovedMessages uint64            `json:"moved_messages"`
+}
+
+// RedriveTaskView reports a redrive task, or every task when listing them.
+type RedriveTaskView struct {
+	Code  queue.Code    `json:"code"`
+	Tasks []RedriveTask `json:"tasks"`
+}
+
+// StartRedriveTask validates a redrive task and starts it. The ID is chosen by the caller,
+// so every replica starts the task under the same ID.
+func (qm *QueueManager) StartRedriveTask(task RedriveTask) RedriveTaskView {
+	qm.Lock.Lock()
+	defer qm.Lock.Unlock()
+
+	if task.ID == "" || qm.redriveTask(task.ID) != nil {
+		return RedriveTaskView{Code: queue.INVALID_REDRIVE_TASK}
+	}
+	if task.MaxMessagesPerSecond == 0 {
+		task.MaxMessagesPerSecond = DefaultRedriveRate
+	}
+	if task.MaxMessagesPerSecond < 0 || task.MaxMessagesPerSecond > MaxRedriveRate {
+		return RedriveTaskView{Code: queue.INVALID_REDRIVE_TASK}
+	}
+	if _, exists := qm.Queues[task.DeadLetterQueue]; !exists {
+		return RedriveTaskView{Code: queue.QUEUE_NOT_FOUND}
+	}
+
+	if task.DestinationQueue == "" {
+		task.DestinationQueue = task.DeadLetterQueue
+		if sources := qm.sourceQueues(task.DeadLetterQueue); len(sources) == 1 {
+			task.DestinationQueue = sources[0]
+		} else if len(sources) > 1 {
+			return RedriveTaskView{Code: queue.INVALID_REDRIVE_TASK} // ambiguous, the destination has to be chosen.
+		}
+	}
+	if _, exists := qm.Queues[task.DestinationQueue]; !exists {
+		return RedriveTaskView{Code: queue.QUEUE_NOT_FOUND}
+	}
+
+	task.Status = RedriveRunning
+	task.MovedMessages = 0
+	qm.redriveTasks = append(qm.redriveTasks, task)
+	return RedriveTaskView{Code: queue.OK, Tasks: []RedriveTask{task}}
+}
+
+// StepRedriveTask moves the next MaxMessagesPerSecond dead letters of a running task and
+// completes it once fewer are left.
+func (qm *QueueManager) StepRedriveTask(now time.Time, id string) RedriveTaskView {
+	qm.Lock.Lock()
+	defer qm.Lock.Unlock()
+
+	task := qm.redriveTask(id)
+	if task == nil {
+		return RedriveTaskView{Code: queue.REDRIVE_TASK_NOT_FOUND}
+	}
+	if task.Status != RedriveRunning {
+		return RedriveTaskView{Code: queue.OK, Tasks: []RedriveTask{*task}}
+	}
+
+	source, sourceExists := qm.Queues[task.DeadLetterQueue]
+	destination, destinationExists := qm.Queues[task.DestinationQueue]
+	if !sourceExists || !destinationExists {
+		task.Status = RedriveFailed
+		return RedriveTaskView{Code: queue.OK, Tasks: []RedriveTask{*task}}
+	}
+
+	var taken queue.Response
+	if len(qm.sourceQueues(task.DeadLetterQueue)) > 0 {
+		taken = source.TakeMessages(now, task.Selector, task.MaxMessagesPerSecond)
+	} else {
+		taken = source.TakeDeadLetters(now, task.Selector, task.MaxMessagesPerSecond)
+	}
+	qm.moveDeadLetters(now, task.DeadLetterQueue, taken)
+	messages := make([]queue.Message, len(taken.Batch))
+	for i, response := range taken.Batch {
+		messages[i] = response.Message
+	}
+	qm.moveDeadLetters(now, task.DestinationQueue, destination.InsertMoved(now, messages))
+
+	task.MovedMessages += uint64(len(messages))
+	if len(messages) < task.MaxMessagesPerSecond {
+		task.Status = RedriveCompleted
+	}
+	return RedriveTaskView{Code: queue.OK, Tasks: []RedriveTask{*task}}
+}
+
+// CancelRedriveTask stops a running redrive task. Messages it already moved stay where they are.
+func (qm *QueueManager) CancelRedriveTask(id string) RedriveTaskView {
+	qm.Lock.Lock()
+	defer qm.Lock.Unlock()
+
+	task := qm.redriveTask(id)
+	if task == nil {
+		return RedriveTaskView{Code: queue.REDRIVE_TASK_NOT_FOUND}
+	}
+	if task.Status == RedriveRunning {
+		task.Status = RedriveCancelled
+	}
+	return RedriveTaskView{Code: queue.OK, Tasks: []RedriveTask{*task}}
+}
+
+// ListRedriveTasks returns the task with the given ID, or every task in the order they were started when id is empty.
+func (qm *QueueManager) ListRedriveTasks(id string) RedriveTaskView {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	if id == "" {
+		return RedriveTaskView{Code: queue.OK, Tasks: append([]RedriveTask{}, qm.redriveTasks...)}
+	}
+	if task := qm.redriveTask(id); task != nil {
+		return RedriveTaskView{Code: queue.OK, Tasks: []RedriveTask{*task}}
+	}
+	return RedriveTaskView{Code: queue.REDRIVE_TASK_NOT_FOUND}
+}
+
+// RunningRedriveTasks returns the IDs of the tasks the leader still has to step.
+func (qm *QueueManager) RunningRedriveTasks() []string {
+	qm.Lock.RLock()
+	defer qm.Lock.RUnlock()
+
+	var running []string
+	for _, task := range qm.redriveTasks {
+		if task.Status == RedriveRunning {
+			running = append(running, task.ID)
+		}
+	}
+	return running
+}
+
+// RestoreRedriveTasks replaces all redrive tasks with the ones from a snapshot.
+func (qm *QueueManager) RestoreRedriveTasks(tasks []RedriveTask) {
+	qm.Lock.Lock()
+	defer qm.Lock.Unlock()
+
+	qm.redriveTasks = append([]RedriveTask{}, tasks...)
+}
+
+func (qm *QueueManager) redriveTask(id string) *RedriveTask {
+	for i := range qm.redriveTasks {
+		if qm.redriveTasks[i].ID == id {
+			return &qm.redriveTasks[i]
+		}
+	}
+	return nil
+}
@@ -1,9 +1,14 @@
 package queue_manager
 
-import "github.com/Weile-Zheng/simplyQ/internal/queue"
+import (
+	"time"
+
+	"github.com/Weile-Zheng/simplyQ/internal/queue"
+)
 
 type CommandType int
 
+// The values are written to the Raft log, so new commands only ever go at the end.
 const (
 	CREATE_QUEUE CommandType = iota
 	DELETE_QUEUE
@@ -13,11 +18,95 @@ const (
 	POP_MESSAGE
 
 	VIEW_QUEUE
+
+	LIST_SCHEDULED
+	CANCEL_SCHEDULED
+
+	SEND_MESSAGE_BATCH
+	RECEIVE_MESSAGE_BATCH
+	DELETE_MESSAGE_BATCH
+
+	CHANGE_VISIBILITY
+
+	LIST_SOURCE_QUEUES
+
+	START_REDRIVE
+	STEP_REDRIVE
+	CANCEL_REDRIVE
+	LIST_REDRIVE_TASKS
+
+	NACK_MESSAGE
+	REJECT_MESSAGE
+
+	PURGE_QUEUE
+
+	GET_QUEUE_CONFIG
+	UPDATE_QUEUE_CONFIG
+
+	TICK
+
+	DELETE_MESSAGE
 )
 
+// Command is one entry of the Raft log. The leader sets the Timestamp when it accepts the command, and
+// every replica applies the command at that time instead of reading its own clock, so replaying the log
+// gives the same state everywhere.
 type Command struct {
-	Type        CommandType       `json:"type"`
-	QueueID     string            `json:"queue_id,omitempty"`
-	Message     queue.Message     `json:"message,omitempty"`
-	QueueConfig queue.QueueConfig `json:"queue_config,omitempty"`
+	Type          CommandType       `json:"type"`
+	Timestamp     time.Time         `json:"timestamp"`
+	QueueID       string            `json:"queue_id,omitempty"`
+	Message       queue.Message     `json:"message,omitempty"`
+	MessageID     string            `json:"message_id,omitempty"`
+	ReceiptHandle string            `json:"receipt_handle,omitempty"`
+	QueueConfig   queue.QueueConfig `json:"queue_config,omitempty"`
+
+	VisibilityTimeout time.Duration `json:"visibility_timeout,omitempty"`
+	Reason            string        `json:"reason,omitempty"`
+
+	Purge queue.PurgeTarget `json:"purge,omitempty"`
+
+	ConfigUpdate queue.ConfigUpdate `json:"config_update,omitempty"`
+
+	QueueIDs []string `json:"queue_ids,omitempty"` // the queues a tick applies to, all queues when empty.
+
+	RedriveTask RedriveTask `json:"redrive_task,omitempty"`
+	TaskID      string      `json:"task_id,omitempty"`
+
+	Messages       []queue.Message `json:"messages,omitempty"`
+	ReceiptHandles []string        `json:"receipt_handles,omitempty"`
+	MaxMessages    int             `json:"max_messages,omitempty"`
+}
+
+// QueueView lists the messages of a queue without receiving them. Delayed messages and the queue's own
+// dead letters are reported apart from the ones that are ready to be received.
+type QueueView struct {
+	Code        queue.Code             `json:"code"`
+	Messages    []queue.Message        `json:"messages"`
+	Delayed     []queue.DelayedMessage `json:"delayed"`
+	Scheduled   []queue.DelayedMessage `json:"scheduled"`
+	DeadLetters []queue.Message        `json:"dead_letters"`
+}
+
+// ConfigView reports the configuration of a queue.
+type ConfigView struct {
+	Code        queue.Code        `json:"code"`
+	QueueConfig queue.QueueConfig `json:"queue_config"`
+}
+
+// SourceQueuesView lists the queues that move their dead letters to a queue.
+type SourceQueuesView struct {
+	Code         queue.Code `json:"code"`
+	SourceQueues []string   `json:"source_queues"`
+}
+
+// PurgeView reports how many messages a purge removed from a queue.
+type PurgeView struct {
+	Code   queue.Code `json:"code"`
+	Purged int        `json:"purged"`
+}
+
+// ScheduledView lists the messages of a queue that are scheduled for a later DeliverAt, earliest first.
+type ScheduledView struct {
+	Code      queue.Code             `json:"code"`
+	Scheduled []queue.DelayedMessage `json:"scheduled"`
 }
@@ -3,14 +3,16 @@ package raft_fsm
 import (
 	"encoding/json"
 	"io"
+	"time"
 
-	"github.com/Weile-Zheng/simplyQ/internal/queue"
 	"github.com/Weile-Zheng/simplyQ/internal/queue_manager"
 	"github.com/hashicorp/raft"
 )
 
 type FSM struct {
 	QueueManager *queue_manager.QueueManager
+
+	lastApplied time.Time // the time the last command was applied at.
 }
 
 func (f *FSM) Apply(log *raft.Log) interface{} {
@@ -19,33 +21,87 @@ func (f *FSM) Apply(log *raft.Log) interface{} {
 		return err
 	}
 
+	// Entries written before commands carried a timestamp fall back to the time the leader appended them.
+	now := command.Timestamp
+	if now.IsZero() {
+		now = log.AppendedAt
+	}
+	// Concurrent requests reach the log in any order and a new leader's clock can be behind the old one's.
+	// Commands are applied at the latest time seen so far, so time never goes back for the queues.
+	if now.Before(f.lastApplied) {
+		now = f.lastApplied
+	}
+	f.lastApplied = now
+
 	switch command.Type {
 	case queue_manager.CREATE_QUEUE:
 		return f.QueueManager.CreateQueue(command.QueueConfig)
 	case queue_manager.DELETE_QUEUE:
 		return f.QueueManager.DeleteQueue(command.QueueID)
+	case queue_manager.GET_QUEUE_CONFIG:
+		return f.QueueManager.GetQueueConfig(now, command.QueueID)
+	case queue_manager.UPDATE_QUEUE_CONFIG:
+		return f.QueueManager.UpdateQueueConfig(now, command.QueueID, command.ConfigUpdate)
 	case queue_manager.SEND_MESSAGE:
-		return f.QueueManager.SendMessage(command.QueueID, command.Message)
+		return f.QueueManager.SendMessage(now, command.QueueID, command.Message)
 	case queue_manager.PEEK_MESSAGE:
-		return f.QueueManager.PeekMessage(command.QueueID)
+		return f.QueueManager.PeekMessage(now, command.QueueID)
 	case queue_manager.POP_MESSAGE:
-		return f.QueueManager.PopMessage(command.QueueID)
+		return f.QueueManager.PopMessage(now, command.QueueID, command.ReceiptHandle)
+	case queue_manager.DELETE_MESSAGE:
+		return f.QueueManager.DeleteMessage(now, command.QueueID, command.MessageID)
+	case queue_manager.CHANGE_VISIBILITY:
+		return f.QueueManager.ChangeMessageVisibility(now, command.QueueID, command.ReceiptHandle, command.VisibilityTimeout)
+	case queue_manager.NACK_MESSAGE:
+		return f.QueueManager.NackMessage(now, command.QueueID, command.ReceiptHandle, command.Reason)
+	case queue_manager.REJECT_MESSAGE:
+		return f.QueueManager.RejectMessage(now, command.QueueID, command.ReceiptHandle, command.Reason)
 	case queue_manager.VIEW_QUEUE:
 		return f.QueueManager.ViewAllMessages(command.QueueID)
+	case queue_manager.LIST_SCHEDULED:
+		return f.QueueManager.ListScheduledMessages(command.QueueID)
+	case queue_manager.CANCEL_SCHEDULED:
+		return f.QueueManager.CancelScheduledMessage(now, command.QueueID, command.MessageID)
+	case queue_manager.SEND_MESSAGE_BATCH:
+		return f.QueueManager.SendMessageBatch(now, command.QueueID, command.Messages)
+	case queue_manager.RECEIVE_MESSAGE_BATCH:
+		return f.QueueManager.ReceiveMessageBatch(now, command.QueueID, command.MaxMessages)
+	case queue_manager.DELETE_MESSAGE_BATCH:
+		return f.QueueManager.DeleteMessageBatch(now, command.QueueID, command.ReceiptHandles)
+	case queue_manager.LIST_SOURCE_QUEUES:
+		return f.QueueManager.ListSourceQueues(command.QueueID)
+	case queue_manager.PURGE_QUEUE:
+		return f.QueueManager.PurgeQueue(now, command.QueueID, command.Purge)
+	case queue_manager.START_REDRIVE:
+		return f.QueueManager.StartRedriveTask(command.RedriveTask)
+	case queue_manager.STEP_REDRIVE:
+		return f.QueueManager.StepRedriveTask(now, command.TaskID)
+	case queue_manager.CANCEL_REDRIVE:
+		return f.QueueManager.CancelRedriveTask(command.TaskID)
+	case queue_manager.LIST_REDRIVE_TASKS:
+		return f.QueueManager.ListRedriveTasks(command.TaskID)
+	case queue_manager.TICK:
+		return f.QueueManager.Tick(now, command.QueueIDs)
 	}
 	return nil
 }
 
 func (f *FSM) Snapshot() (raft.FSMSnapshot, error) {
-	snapshot := RaftSnapshot{f.QueueManager.ViewAllQueues()}
+	snapshot := RaftSnapshot{
+		Queues:       f.QueueManager.ViewAllQueues(),
+		RedriveTasks: f.QueueManager.ListRedriveTasks("").Tasks,
+		LastApplied:  f.lastApplied,
+	}
 	return &snapshot, nil
 }
 
 func (f *FSM) Restore(rc io.ReadCloser) error {
-	var queues map[string]queue.Queue
-	if err := json.NewDecoder(rc).Decode(&queues); err != nil {
+	snapshot, err := decodeSnapshot(rc)
+	if err != nil {
 		return err
 	}
-	f.QueueManager.RestoreAllQueues(queues)
+	f.QueueManager.RestoreAllQueues(snapshot.Queues)
+	f.QueueManager.RestoreRedriveTasks(snapshot.RedriveTasks)
+	f.lastApplied = snapshot.LastApplied
 	return nil
 }
@@ -2,17 +2,38 @@ package raft_fsm
 
 import (
 	"encoding/json"
+	"io"
+	"time"
 
 	"github.com/Weile-Zheng/simplyQ/internal/queue"
+	"github.com/Weile-Zheng/simplyQ/internal/queue_manager"
 	"github.com/hashicorp/raft"
 )
 
+// snapshotVersion is written with every snapshot. Snapshots without a version are a bare map of queues.
+const snapshotVersion = 2
+
 type RaftSnapshot struct {
-	Queues map[string]queue.Queue
+	Queues       map[string]queue.Queue
+	RedriveTasks []queue_manager.RedriveTask
+	LastApplied  time.Time // the time the last command in the snapshot was applied at.
+}
+
+// snapshotData is the persisted form of a RaftSnapshot.
+type snapshotData struct {
+	Version      int                         `json:"version"`
+	Queues       map[string]queue.Queue      `json:"queues"`
+	RedriveTasks []queue_manager.RedriveTask `json:"redrive_tasks"`
+	LastApplied  time.Time                   `json:"last_applied"`
 }
 
 func (s *RaftSnapshot) Persist(sink raft.SnapshotSink) error {
-	data, err := json.Marshal(s.Queues)
+	data, err := json.Marshal(snapshotData{
+		Version:      snapshotVersion,
+		Queues:       s.Queues,
+		RedriveTasks: s.RedriveTasks,
+		LastApplied:  s.LastApplied,
+	})
 	if err != nil {
 		sink.Cancel()
 		return err
@@ -30,3 +51,41 @@ func (s *RaftSnapshot) Persist(sink raft.SnapshotSink) error {
 func (s *RaftSnapshot) Release() {
 	// No additional resources allocated during persist
 }
+
+// decodeSnapshot reads a persisted snapshot, including the bare map of queues written before snapshots had a version.
+func decodeSnapshot(r io.Reader) (RaftSnapshot, error) {
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return RaftSnapshot{}, err
+	}
+
+	// A queue is always a JSON object, so a numeric version can only come from a versioned snapshot.
+	var version int
+	if json.Unmarshal(raw["version"], &version) != nil || version == 0 {
+		queues := make(map[string]queue.Queue, len(raw))
+		for id, data := range raw {
+			var q queue.Queue
+			if err := json.Unmarshal(data, &q); err != nil {
+				return RaftSnapshot{}, err
+			}
+			queues[id] = q
+		}
+		return RaftSnapshot{Queues: queues}, nil
+	}
+
+	var data snapshotData
+	if err := json.Unmarshal(raw["queues"], &data.Queues); err != nil {
+		return RaftSnapshot{}, err
+	}
+	if tasks, exists := raw["redrive_tasks"]; exists {
+		if err := json.Unmarshal(tasks, &data.RedriveTasks); err != nil {
+			return RaftSnapshot{}, err
+		}
+	}
+	if lastApplied, exists := raw["last_applied"]; exists {
+		if err := json.Unmarshal(lastApplied, &data.LastApplied); err != nil {
+			return RaftSnapshot{}, err
+		}
+	}
+	return RaftSnapshot{Queues: data.Queues, RedriveTasks: data.RedriveTasks, LastApplied: data.LastApplied}, nil
+}
@@ -1,9 +1,14 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/Weile-Zheng/simplyQ/internal/queue"
@@ -11,6 +16,179 @@ import (
 	"github.com/hashicorp/raft"
 )
 
+const rawBodyContentType = "application/octet-stream"
+
+// maxMessageRequestSize bounds the request of a single JSON send. A body at the cluster limit takes up to
+// six times as many bytes in JSON, when every byte is a control character escaped as \u00XX, and the
+// other fields of the message get room of their own.
+const maxMessageRequestSize = 6*int64(queue.MaxMessageSizeLimit) + 64*1024
+
+// writeMessageTooLarge rejects a send whose message is over the cluster limit.
+func writeMessageTooLarge(w http.ResponseWriter) {
+	w.WriteHeader(statusForCode(queue.MESSAGE_TOO_LARGE))
+	json.NewEncoder(w).Encode(map[string]any{
+		"code": queue.MESSAGE_TOO_LARGE,
+	})
+}
+
+// tooLarge reports whether reading a request failed because it is larger than http.MaxBytesReader allows.
+func tooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// decodeMessage reads a message from a send request. JSON requests carry the whole message, a body
+// with BodyEncoding base64 is decoded to raw bytes. Any other content type is taken as the raw message
+// body, with the content type kept on the message and the remaining fields read from query parameters.
+func decodeMessage(r *http.Request) (queue.Message, error) {
+	var message queue.Message
+
+	contentType := r.Header.Get("Content-Type")
+	mediaType := ""
+	if contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return message, err
+		}
+	}
+
+	if mediaType == "" || mediaType == "application/json" {
+		err := json.NewDecoder(r.Body).Decode(&message)
+		return message, err
+	}
+
+	// Read one byte past the cluster limit so oversized bodies are rejected without reading all of them.
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(queue.MaxMessageSizeLimit)+1))
+	if err != nil {
+		return message, err
+	}
+
+	query := r.URL.Query()
+	message.Body = body
+	message.ContentType = contentType
+	message.MessageGroupID = query.Get("messageGroupID")
+	message.DeduplicationID = query.Get("deduplicationID")
+	if delay := query.Get("delaySeconds"); delay != "" {
+		seconds, err := strconv.ParseUint(delay, 10, 32)
+		if err != nil {
+			return message, fmt.Errorf("invalid delaySeconds: %w", err)
+		}
+		message.DelaySeconds = uint32(seconds)
+	}
+	if priority := query.Get("priority"); priority != "" {
+		value, err := strconv.Atoi(priority)
+		if err != nil {
+			return message, fmt.Errorf("invalid priority: %w", err)
+		}
+		message.Priority = value
+	}
+	return message, nil
+}
+
+// stampMessage assigns a new message its ID and sent timestamp. The leader stamps messages before
+// they are written to the Raft log, so every replica stores the same values, whatever the client sent.
+func (s *QueueServer) stampMessage(message *queue.Message) error {
+	now := time.Now()
+	id, err := s.MessageIDs.New(now)
+	if err != nil {
+		return err
+	}
+	message.ID = id
+	message.TimeStamp = now
+	return nil
+}
+
+// writeRawMessage writes a received message body as is, with its ID, receipt handle and receive count in headers.
+func writeRawMessage(w http.ResponseWriter, response queue.Response) {
+	contentType := response.Message.ContentType
+	if contentType == "" {
+		contentType = rawBodyContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Message-Id", response.Message.ID)
+	w.Header().Set("X-Receipt-Handle", response.ReceiptHandle)
+	w.Header().Set("X-Receive-Count", strconv.Itoa(int(response.Message.ReceiveCount)))
+	w.Header().Set("X-First-Receive-Timestamp", response.Message.FirstReceiveTimestamp.Format(time.RFC3339Nano))
+	w.Write(response.Message.Body)
+}
+
+const maxWaitTime = 20 * time.Second // the longest a receive can wait for a message.
+
+// parseWaitTime reads the optional waitTimeSeconds query parameter of a receive.
+func parseWaitTime(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("waitTimeSeconds")
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 || time.Duration(seconds)*time.Second > maxWaitTime {
+		return 0, fmt.Errorf("waitTimeSeconds must be between 0 and %d", int(maxWaitTime.Seconds()))
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// receiveWithWait applies a receive command, and while the queue is empty waits up to waitTime for a message
+// before applying it again. The waiter is registered before each receive, so a message sent in between still
+// wakes it. Messages released from a delay or a visibility timeout wake it as well, when a TICK releases them,
+// so a receive is only applied again once a message may be ready. Waiting happens outside of the Raft apply
+// path and without holding the QueueManager lock. Every attempt is stamped with the time it is applied, so
+// the visibility timeout starts with the receive.
+func (s *QueueServer) receiveWithWait(ctx context.Context, queueID string, command queue_manager.Command, waitTime time.Duration) (any, error) {
+	deadline := time.Now().Add(waitTime)
+	for {
+		var wake <-chan struct{}
+		if waitTime > 0 {
+			wake = s.QueueManager.WaitForMessage(queueID, deadline)
+		}
+
+		command.Timestamp = time.Now()
+		commandBytes, err := json.Marshal(command)
+		if err != nil {
+			return nil, err
+		}
+		response, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second)
+		if err != nil {
+			return nil, err
+		}
+
+		remaining := time.Until(deadline)
+		if receiveResponse, ok := response.(queue.Response); !ok || receiveResponse.Code != queue.EMPTY_QUEUE || wake == nil || remaining <= 0 {
+			return response, nil
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-wake:
+			timer.Stop()
+		case <-timer.C:
+			return response, nil
+		case <-ctx.Done():
+			timer.Stop()
+			return response, nil
+		}
+	}
+}
+
+// statusForCode maps queue codes that reject the request to an HTTP status.
+// Other codes are reported in the response body with 200 OK.
+func statusForCode(code queue.Code) int {
+	switch code {
+	case queue.MESSAGE_TOO_LARGE:
+		return http.StatusRequestEntityTooLarge
+	case queue.INVALID_QUEUE_CONFIG, queue.INVALID_MESSAGE, queue.INVALID_BATCH_SIZE, queue.INVALID_VISIBILITY_TIMEOUT,
+		queue.DEAD_LETTER_TARGET_NOT_FOUND, queue.INVALID_REDRIVE_TASK:
+		return http.StatusBadRequest
+	case queue.QUEUE_IN_USE:
+		return http.StatusConflict
+	case queue.INVALID_PURGE_TARGET:
+		return http.StatusBadRequest
+	case queue.PURGE_IN_PROGRESS:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusOK
+	}
+}
+
 func (s *QueueServer) pingHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Pong\n")
 }
@@ -28,8 +206,14 @@ func (s *QueueServer) createQueueHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if config.MaxMessageSize > queue.MaxMessageSizeLimit {
+		http.Error(w, fmt.Sprintf("MaxMessageSize exceeds the cluster limit of %d bytes", queue.MaxMessageSizeLimit), http.StatusBadRequest)
+		return
+	}
+
 	command := queue_manager.Command{
 		Type:        queue_manager.CREATE_QUEUE,
+		Timestamp:   time.Now(),
 		QueueConfig: config,
 	}
 
@@ -45,6 +229,9 @@ func (s *QueueServer) createQueueHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if code, ok := code.(queue.Code); ok {
+		w.WriteHeader(statusForCode(code))
+	}
 	json.NewEncoder(w).Encode(map[string]any{
 		"code":         code,
 		"queue_config": config,
@@ -64,16 +251,33 @@ func (s *QueueServer) sendMessageHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	var message queue.Message
-	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
-		http.Error(w, "Invalid message format", http.StatusBadRequest)
+	r.Body = http.MaxBytesReader(w, r.Body, maxMessageRequestSize)
+	message, err := decodeMessage(r)
+	if tooLarge(err) {
+		writeMessageTooLarge(w)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid message format: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Reject oversized bodies before they are ever written to the Raft log.
+	if uint64(message.Size()) > uint64(queue.MaxMessageSizeLimit) {
+		writeMessageTooLarge(w)
+		return
+	}
+
+	if err := s.stampMessage(&message); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to assign message ID: %v", err), http.StatusInternalServerError)
 		return
 	}
 
 	command := queue_manager.Command{
-		Type:    queue_manager.SEND_MESSAGE,
-		QueueID: queueID,
-		Message: message,
+		Type:      queue_manager.SEND_MESSAGE,
+		Timestamp: time.Now(),
+		QueueID:   queueID,
+		Message:   message,
 	}
 
 	commandBytes, err := json.Marshal(command)
@@ -82,16 +286,23 @@ func (s *QueueServer) sendMessageHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	code, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second)
+	response, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to apply command: %v", err), http.StatusInternalServerError)
 		return
 	}
+
+	sendResponse, ok := response.(queue.Response)
+	if !ok {
+		http.Error(w, "Unexpected response type from queue manager", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(statusForCode(sendResponse.Code))
 	json.NewEncoder(w).Encode(map[string]any{
-		"code":    code,
-		"message": message,
+		"code":    sendResponse.Code,
+		"message": sendResponse.Message,
 	})
-
 }
 
 // peekMessageHandler gets the next message from a queue without removing it
@@ -107,18 +318,18 @@ func (s *QueueServer) peekMessageHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	waitTime, err := parseWaitTime(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	command := queue_manager.Command{
 		Type:    queue_manager.PEEK_MESSAGE,
 		QueueID: queueID,
 	}
 
-	commandBytes, err := json.Marshal(command)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to marshal command: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	response, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second)
+	response, err := s.receiveWithWait(r.Context(), queueID, command, waitTime)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to apply command: %v", err), http.StatusInternalServerError)
 		return
@@ -131,9 +342,16 @@ func (s *QueueServer) peekMessageHandler(w http.ResponseWriter, r *http.Request)
 			"code": peekResponse.Code,
 		}
 
+		// Clients asking for raw bytes get the body as is, with the metadata in headers
+		if peekResponse.Code == queue.OK && r.Header.Get("Accept") == rawBodyContentType {
+			writeRawMessage(w, peekResponse)
+			return
+		}
+
 		// Only include message if one was found
 		if peekResponse.Code == queue.OK {
 			result["message"] = peekResponse.Message
+			result["receipt_handle"] = peekResponse.ReceiptHandle
 		}
 
 		json.NewEncoder(w).Encode(result)
@@ -152,7 +370,7 @@ func (s *QueueServer) peekMessageHandler(w http.ResponseWriter, r *http.Request)
 	http.Error(w, "Unexpected response type from queue manager", http.StatusInternalServerError)
 }
 
-// popMessageHandler removes the first(current) message from a queue
+// popMessageHandler deletes a received message from a queue using the receipt handle returned by peek
 func (s *QueueServer) popMessageHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -165,9 +383,17 @@ func (s *QueueServer) popMessageHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	receiptHandle := r.URL.Query().Get("receiptHandle")
+	if receiptHandle == "" {
+		http.Error(w, "Missing receipt handle", http.StatusBadRequest)
+		return
+	}
+
 	command := queue_manager.Command{
-		Type:    queue_manager.POP_MESSAGE,
-		QueueID: queueID,
+		Type:          queue_manager.POP_MESSAGE,
+		Timestamp:     time.Now(),
+		QueueID:       queueID,
+		ReceiptHandle: receiptHandle,
 	}
 
 	commandBytes, err := json.Marshal(command)
@@ -182,9 +408,167 @@ func (s *QueueServer) popMessageHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	popResponse, ok := response.(queue.Response)
+	if !ok {
+		http.Error(w, "Unexpected response type from queue manager", http.StatusInternalServerError)
+		return
+	}
+
 	// Return the response code
 	json.NewEncoder(w).Encode(map[string]any{
-		"code": response,
+		"code": popResponse.Code,
+	})
+}
+
+// deleteMessageHandler deletes a message from a queue either by its messageID, whether or not it was received,
+// or by the receiptHandle of a receive like popMessage
+func (s *QueueServer) deleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queueID := r.URL.Query().Get("queueID")
+	if queueID == "" {
+		http.Error(w, "Missing queue ID", http.StatusBadRequest)
+		return
+	}
+
+	command := queue_manager.Command{
+		Timestamp:     time.Now(),
+		QueueID:       queueID,
+		MessageID:     r.URL.Query().Get("messageID"),
+		ReceiptHandle: r.URL.Query().Get("receiptHandle"),
+	}
+	switch {
+	case command.MessageID != "" && command.ReceiptHandle == "":
+		command.Type = queue_manager.DELETE_MESSAGE
+	case command.ReceiptHandle != "" && command.MessageID == "":
+		command.Type = queue_manager.POP_MESSAGE
+	default:
+		http.Error(w, "Exactly one of message ID and receipt handle is required", http.StatusBadRequest)
+		return
+	}
+
+	commandBytes, err := json.Marshal(command)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to marshal command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to apply command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	deleteResponse, ok := response.(queue.Response)
+	if !ok {
+		http.Error(w, "Unexpected response type from queue manager", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"code": deleteResponse.Code,
+	})
+}
+
+// changeMessageVisibilityHandler extends or shortens how long a received message stays hidden,
+// visibilityTimeout is in seconds and 0 releases the message right away.
+func (s *QueueServer) changeMessageVisibilityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queueID := r.URL.Query().Get("queueID")
+	if queueID == "" {
+		http.Error(w, "Missing queue ID", http.StatusBadRequest)
+		return
+	}
+
+	receiptHandle := r.URL.Query().Get("receiptHandle")
+	if receiptHandle == "" {
+		http.Error(w, "Missing receipt handle", http.StatusBadRequest)
+		return
+	}
+
+	seconds, err := strconv.ParseUint(r.URL.Query().Get("visibilityTimeout"), 10, 32)
+	if err != nil {
+		http.Error(w, "Invalid visibilityTimeout", http.StatusBadRequest)
+		return
+	}
+
+	s.applyReceiptCommand(w, queue_manager.Command{
+		Type:              queue_manager.CHANGE_VISIBILITY,
+		Timestamp:         time.Now(),
+		QueueID:           queueID,
+		ReceiptHandle:     receiptHandle,
+		VisibilityTimeout: time.Duration(seconds) * time.Second,
+	})
+}
+
+// nackMessageHandler makes a received message visible again right away, storing the optional reason
+// the consumer failed to process it on the message.
+func (s *QueueServer) nackMessageHandler(w http.ResponseWriter, r *http.Request) {
+	s.failMessageHandler(w, r, queue_manager.NACK_MESSAGE)
+}
+
+// rejectMessageHandler dead letters a received message right away with the optional reason.
+func (s *QueueServer) rejectMessageHandler(w http.ResponseWriter, r *http.Request) {
+	s.failMessageHandler(w, r, queue_manager.REJECT_MESSAGE)
+}
+
+func (s *QueueServer) failMessageHandler(w http.ResponseWriter, r *http.Request, commandType queue_manager.CommandType) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queueID := r.URL.Query().Get("queueID")
+	if queueID == "" {
+		http.Error(w, "Missing queue ID", http.StatusBadRequest)
+		return
+	}
+
+	receiptHandle := r.URL.Query().Get("receiptHandle")
+	if receiptHandle == "" {
+		http.Error(w, "Missing receipt handle", http.StatusBadRequest)
+		return
+	}
+
+	s.applyReceiptCommand(w, queue_manager.Command{
+		Type:          commandType,
+		Timestamp:     time.Now(),
+		QueueID:       queueID,
+		ReceiptHandle: receiptHandle,
+		Reason:        r.URL.Query().Get("reason"),
+	})
+}
+
+// applyReceiptCommand applies a command on a received message and writes the resulting code.
+func (s *QueueServer) applyReceiptCommand(w http.ResponseWriter, command queue_manager.Command) {
+	commandBytes, err := json.Marshal(command)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to marshal command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to apply command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	receiptResponse, ok := response.(queue.Response)
+	if !ok {
+		http.Error(w, "Unexpected response type from queue manager", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(statusForCode(receiptResponse.Code))
+	json.NewEncoder(w).Encode(map[string]any{
+		"code": receiptResponse.Code,
 	})
 }
 
@@ -202,8 +586,9 @@ func (s *QueueServer) viewQueueHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	command := queue_manager.Command{
-		Type:    queue_manager.VIEW_QUEUE,
-		QueueID: queueID,
+		Type:      queue_manager.VIEW_QUEUE,
+		Timestamp: time.Now(),
+		QueueID:   queueID,
 	}
 
 	commandBytes, err := json.Marshal(command)
@@ -212,15 +597,324 @@ func (s *QueueServer) viewQueueHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	code, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second)
+	response, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second)
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to apply command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	view, ok := response.(queue_manager.QueueView)
+	if !ok {
+		http.Error(w, "Unexpected response type from queue manager", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(view)
+}
+
+// sendMessageBatchHandler sends a JSON array of messages to a queue as a single Raft command
+func (s *QueueServer) sendMessageBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queueID := r.URL.Query().Get("queueID")
+	if queueID == "" {
+		http.Error(w, "Missing queue ID", http.StatusBadRequest)
+		return
+	}
+
+	var messages []queue.Message
+	r.Body = http.MaxBytesReader(w, r.Body, queue.MaxBatchSize*maxMessageRequestSize)
+	err := json.NewDecoder(r.Body).Decode(&messages)
+	if tooLarge(err) {
+		writeMessageTooLarge(w)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid message format: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if len(messages) == 0 || len(messages) > queue.MaxBatchSize {
+		w.WriteHeader(statusForCode(queue.INVALID_BATCH_SIZE))
+		json.NewEncoder(w).Encode(map[string]any{
+			"code": queue.INVALID_BATCH_SIZE,
+		})
+		return
+	}
+
+	// Oversized entries fail on their own and never reach the Raft log, the rest are sent together.
+	results := make([]queue.Response, len(messages))
+	var accepted []queue.Message
+	var positions []int
+	for i, message := range messages {
+		if uint64(message.Size()) > uint64(queue.MaxMessageSizeLimit) {
+			results[i] = queue.Response{Code: queue.MESSAGE_TOO_LARGE}
+			continue
+		}
+		if err := s.stampMessage(&message); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to assign message ID: %v", err), http.StatusInternalServerError)
+			return
+		}
+		accepted = append(accepted, message)
+		positions = append(positions, i)
+	}
+
+	if len(accepted) > 0 {
+		command := queue_manager.Command{
+			Type:      queue_manager.SEND_MESSAGE_BATCH,
+			Timestamp: time.Now(),
+			QueueID:   queueID,
+			Messages:  accepted,
+		}
+
+		commandBytes, err := json.Marshal(command)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to marshal command: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		response, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to apply command: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		batchResponse, ok := response.(queue.Response)
+		if !ok {
+			http.Error(w, "Unexpected response type from queue manager", http.StatusInternalServerError)
+			return
+		}
+		if batchResponse.Code != queue.OK {
+			w.WriteHeader(statusForCode(batchResponse.Code))
+			json.NewEncoder(w).Encode(map[string]any{
+				"code": batchResponse.Code,
+			})
+			return
+		}
+		for i, result := range batchResponse.Batch {
+			results[positions[i]] = result
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"code":    queue.OK,
+		"results": batchResults(results),
+	})
+}
+
+// receiveMessageBatchHandler receives up to maxMessages messages from a queue
+func (s *QueueServer) receiveMessageBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queueID := r.URL.Query().Get("queueID")
+	if queueID == "" {
+		http.Error(w, "Missing queue ID", http.StatusBadRequest)
+		return
+	}
+
+	maxMessages := queue.MaxBatchSize
+	if value := r.URL.Query().Get("maxMessages"); value != "" {
+		var err error
+		if maxMessages, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "Invalid maxMessages", http.StatusBadRequest)
+			return
+		}
+	}
+
+	waitTime, err := parseWaitTime(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	command := queue_manager.Command{
+		Type:        queue_manager.RECEIVE_MESSAGE_BATCH,
+		QueueID:     queueID,
+		MaxMessages: maxMessages,
+	}
+
+	response, err := s.receiveWithWait(r.Context(), queueID, command, waitTime)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to apply command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	batchResponse, ok := response.(queue.Response)
+	if !ok {
+		http.Error(w, "Unexpected response type from queue manager", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(statusForCode(batchResponse.Code))
+	json.NewEncoder(w).Encode(map[string]any{
+		"code":    batchResponse.Code,
+		"results": batchResults(batchResponse.Batch),
+	})
+}
 
+// deleteMessageBatchHandler deletes the in flight messages of a JSON array of receipt handles
+func (s *QueueServer) deleteMessageBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queueID := r.URL.Query().Get("queueID")
+	if queueID == "" {
+		http.Error(w, "Missing queue ID", http.StatusBadRequest)
+		return
+	}
+
+	var receiptHandles []string
+	if err := json.NewDecoder(r.Body).Decode(&receiptHandles); err != nil {
+		http.Error(w, "Invalid receipt handles", http.StatusBadRequest)
+		return
+	}
+
+	command := queue_manager.Command{
+		Type:           queue_manager.DELETE_MESSAGE_BATCH,
+		Timestamp:      time.Now(),
+		QueueID:        queueID,
+		ReceiptHandles: receiptHandles,
+	}
+
+	commandBytes, err := json.Marshal(command)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to marshal command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to apply command: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	batchResponse, ok := response.(queue.Response)
+	if !ok {
+		http.Error(w, "Unexpected response type from queue manager", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(statusForCode(batchResponse.Code))
 	json.NewEncoder(w).Encode(map[string]any{
-		"code": code,
+		"code":    batchResponse.Code,
+		"results": batchResults(batchResponse.Batch),
+	})
+}
+
+// batchResults lists the result of every batch entry in the same shape as the single message endpoints.
+func batchResults(batch []queue.Response) []map[string]any {
+	results := make([]map[string]any, len(batch))
+	for i, response := range batch {
+		result := map[string]any{
+			"code": response.Code,
+		}
+		if response.Code == queue.OK {
+			result["message"] = response.Message
+		}
+		if response.ReceiptHandle != "" {
+			result["receipt_handle"] = response.ReceiptHandle
+		}
+		results[i] = result
+	}
+	return results
+}
+
+// listScheduledHandler lists the messages of a queue that are scheduled for a later DeliverAt
+func (s *QueueServer) listScheduledHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queueID := r.URL.Query().Get("queueID")
+	if queueID == "" {
+		http.Error(w, "Missing queue ID", http.StatusBadRequest)
+		return
+	}
+
+	command := queue_manager.Command{
+		Type:      queue_manager.LIST_SCHEDULED,
+		Timestamp: time.Now(),
+		QueueID:   queueID,
+	}
+
+	commandBytes, err := json.Marshal(command)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to marshal command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to apply command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	view, ok := response.(queue_manager.ScheduledView)
+	if !ok {
+		http.Error(w, "Unexpected response type from queue manager", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(view)
+}
+
+// cancelScheduledHandler cancels a scheduled message by its ID before it is delivered
+func (s *QueueServer) cancelScheduledHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queueID := r.URL.Query().Get("queueID")
+	if queueID == "" {
+		http.Error(w, "Missing queue ID", http.StatusBadRequest)
+		return
+	}
+
+	messageID := r.URL.Query().Get("messageID")
+	if messageID == "" {
+		http.Error(w, "Missing message ID", http.StatusBadRequest)
+		return
+	}
+
+	command := queue_manager.Command{
+		Type:      queue_manager.CANCEL_SCHEDULED,
+		Timestamp: time.Now(),
+		QueueID:   queueID,
+		MessageID: messageID,
+	}
+
+	commandBytes, err := json.Marshal(command)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to marshal command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to apply command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	cancelResponse, ok := response.(queue.Response)
+	if !ok {
+		http.Error(w, "Unexpected response type from queue manager", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"code":    cancelResponse.Code,
+		"message": cancelResponse.Message,
 	})
 }
 
@@ -270,3 +964,91 @@ func (s *QueueServer) raftJoinHandler(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Fprintf(w, "Node %s at %s successfully joined the cluster", req.ID, req.Address)
 }
+
+// listSourceQueuesHandler lists the queues whose redrive policy moves dead letters to a queue
+func (s *QueueServer) listSourceQueuesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queueID := r.URL.Query().Get("queueID")
+	if queueID == "" {
+		http.Error(w, "Missing queue ID", http.StatusBadRequest)
+		return
+	}
+
+	command := queue_manager.Command{
+		Type:      queue_manager.LIST_SOURCE_QUEUES,
+		Timestamp: time.Now(),
+		QueueID:   queueID,
+	}
+
+	commandBytes, err := json.Marshal(command)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to marshal command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to apply command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	view, ok := response.(queue_manager.SourceQueuesView)
+	if !ok {
+		http.Error(w, "Unexpected response type from queue manager", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(view)
+}
+
+// purgeQueueHandler removes the messages of a queue, its dead letters or both as chosen by target,
+// which defaults to the messages. A queue can only be purged once per cooldown.
+func (s *QueueServer) purgeQueueHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queueID := r.URL.Query().Get("queueID")
+	if queueID == "" {
+		http.Error(w, "Missing queue ID", http.StatusBadRequest)
+		return
+	}
+
+	target := queue.PurgeTarget(r.URL.Query().Get("target"))
+	if target == "" {
+		target = queue.PurgeMessages
+	}
+
+	command := queue_manager.Command{
+		Type:      queue_manager.PURGE_QUEUE,
+		Timestamp: time.Now(),
+		QueueID:   queueID,
+		Purge:     target,
+	}
+
+	commandBytes, err := json.Marshal(command)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to marshal command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to apply command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	view, ok := response.(queue_manager.PurgeView)
+	if !ok {
+		http.Error(w, "Unexpected response type from queue manager", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(statusForCode(view.Code))
+	json.NewEncoder(w).Encode(view)
+}
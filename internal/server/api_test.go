@@ -0,0 +1,48 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Weile-Zheng/simplyQ/internal/queue"
+)
+
+// The handlers reject oversized requests before anything is written to the Raft log, so they are
+// tested here without a Raft node.
+func TestOversizedSendRequests(t *testing.T) {
+	overLimit := strings.Repeat("A", int(maxMessageRequestSize))
+	tests := []struct {
+		name        string
+		handler     func(*QueueServer, http.ResponseWriter, *http.Request)
+		path        string
+		contentType string
+		body        string
+	}{
+		// The message is small, only the request is over the limit, so the read itself has to be capped
+		{"JSON", (*QueueServer).sendMessageHandler, "/sendMessage", "application/json", `{"body":"QQ==","padding":"` + overLimit + `"}`},
+		{"raw", (*QueueServer).sendMessageHandler, "/sendMessage", rawBodyContentType, strings.Repeat("A", int(queue.MaxMessageSizeLimit)+1)},
+		{"batch", (*QueueServer).sendMessageBatchHandler, "/sendMessageBatch", "application/json", `[{"body":"` + strings.Repeat(overLimit, queue.MaxBatchSize) + `"}]`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, test.path+"?queueID=TestQueue", strings.NewReader(test.body))
+			r.Header.Set("Content-Type", test.contentType)
+			w := httptest.NewRecorder()
+			test.handler(&QueueServer{}, w, r)
+
+			if w.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusRequestEntityTooLarge, w.Code, w.Body)
+			}
+			var response struct {
+				Code queue.Code `json:"code"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil || response.Code != queue.MESSAGE_TOO_LARGE {
+				t.Errorf("Expected code MESSAGE_TOO_LARGE, got %d (%v)", response.Code, err)
+			}
+		})
+	}
+}
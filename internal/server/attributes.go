@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Weile-Zheng/simplyQ/internal/queue"
+	"github.com/Weile-Zheng/simplyQ/internal/queue_manager"
+)
+
+// getAttributesHandler returns the configuration of the queue in the path.
+func (s *QueueServer) getAttributesHandler(w http.ResponseWriter, r *http.Request) {
+	s.applyConfigCommand(w, queue_manager.Command{
+		Type:      queue_manager.GET_QUEUE_CONFIG,
+		Timestamp: time.Now(),
+		QueueID:   r.PathValue("name"),
+	})
+}
+
+// updateAttributesHandler changes the fields of the configuration of the queue in the path that are
+// set in the JSON body, the others keep their value. The name and type can not be changed.
+func (s *QueueServer) updateAttributesHandler(w http.ResponseWriter, r *http.Request) {
+	var update queue.ConfigUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if update.MaxMessageSize != nil && *update.MaxMessageSize > queue.MaxMessageSizeLimit {
+		http.Error(w, fmt.Sprintf("MaxMessageSize exceeds the cluster limit of %d bytes", queue.MaxMessageSizeLimit), http.StatusBadRequest)
+		return
+	}
+
+	s.applyConfigCommand(w, queue_manager.Command{
+		Type:         queue_manager.UPDATE_QUEUE_CONFIG,
+		Timestamp:    time.Now(),
+		QueueID:      r.PathValue("name"),
+		ConfigUpdate: update,
+	})
+}
+
+func (s *QueueServer) applyConfigCommand(w http.ResponseWriter, command queue_manager.Command) {
+	commandBytes, err := json.Marshal(command)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to marshal command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to apply command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	view, ok := response.(queue_manager.ConfigView)
+	if !ok {
+		http.Error(w, "Unexpected response type from queue manager", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(statusForCode(view.Code))
+	json.NewEncoder(w).Encode(view)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Weile-Zheng/simplyQ/internal/queue_manager"
+)
+
+// redriveInterval is how often the leader steps running redrive tasks, each step moves up to
+// MaxMessagesPerSecond messages.
+const redriveInterval = time.Second
+
+// runRedriveTasks steps every running redrive task through Raft while this node is the leader.
+// Followers keep the loop running, so a node that becomes leader continues the tasks of the old one.
+func (s *QueueServer) runRedriveTasks() {
+	ticker := time.NewTicker(redriveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !s.RaftNode.IsLeader() {
+			continue
+		}
+		for _, id := range s.QueueManager.RunningRedriveTasks() {
+			command := queue_manager.Command{
+				Type:      queue_manager.STEP_REDRIVE,
+				Timestamp: time.Now(),
+				TaskID:    id,
+			}
+			commandBytes, err := json.Marshal(command)
+			if err != nil {
+				log.Printf("Failed to marshal redrive step of task %s: %v", id, err)
+				continue
+			}
+			if _, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second); err != nil {
+				log.Printf("Failed to step redrive task %s: %v", id, err)
+			}
+		}
+	}
+}
+
+// startRedriveHandler starts a redrive task from a JSON body with the dead_letter_queue, an optional
+// destination_queue, selector and max_messages_per_second.
+func (s *QueueServer) startRedriveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var task queue_manager.RedriveTask
+	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid redrive task: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to generate task ID: %v", err), http.StatusInternalServerError)
+		return
+	}
+	task.ID = hex.EncodeToString(id)
+
+	s.applyRedriveCommand(w, queue_manager.Command{
+		Type:        queue_manager.START_REDRIVE,
+		Timestamp:   time.Now(),
+		RedriveTask: task,
+	})
+}
+
+// listRedriveHandler reports the redrive task given by taskID, or every task without it
+func (s *QueueServer) listRedriveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.applyRedriveCommand(w, queue_manager.Command{
+		Type:      queue_manager.LIST_REDRIVE_TASKS,
+		Timestamp: time.Now(),
+		TaskID:    r.URL.Query().Get("taskID"),
+	})
+}
+
+// cancelRedriveHandler stops a running redrive task
+func (s *QueueServer) cancelRedriveHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	taskID := r.URL.Query().Get("taskID")
+	if taskID == "" {
+		http.Error(w, "Missing task ID", http.StatusBadRequest)
+		return
+	}
+
+	s.applyRedriveCommand(w, queue_manager.Command{
+		Type:      queue_manager.CANCEL_REDRIVE,
+		Timestamp: time.Now(),
+		TaskID:    taskID,
+	})
+}
+
+func (s *QueueServer) applyRedriveCommand(w http.ResponseWriter, command queue_manager.Command) {
+	commandBytes, err := json.Marshal(command)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to marshal command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to apply command: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	view, ok := response.(queue_manager.RedriveTaskView)
+	if !ok {
+		http.Error(w, "Unexpected response type from queue manager", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(statusForCode(view.Code))
+	json.NewEncoder(w).Encode(view)
+}
@@ -7,17 +7,21 @@ import (
 
 	"github.com/Weile-Zheng/simplyQ/internal/queue_manager"
 	raftnode "github.com/Weile-Zheng/simplyQ/internal/raft_fsm"
+	"github.com/Weile-Zheng/simplyQ/internal/ulid"
 )
 
 type QueueServer struct {
-	RaftNode *raftnode.RaftNode
+	RaftNode     *raftnode.RaftNode
+	QueueManager *queue_manager.QueueManager // local state of the FSM, only read to wait for messages and find running redrive tasks without going through Raft.
+	MessageIDs   *ulid.Generator             // assigns the ID of every message the leader accepts.
+	Tick         TickConfig
 }
 
 var managerConfig = queue_manager.QueueManagerConfig{
 	Name: "SimplyQManager",
 }
 
-func StartNewServer(dataDir, nodeID, bindAddr string, raftPort string, httpPort string, peers []string) {
+func StartNewServer(dataDir, nodeID, bindAddr string, raftPort string, httpPort string, peers []string, tick TickConfig) {
 	queueManager := queue_manager.NewQueueManager(managerConfig)
 
 	raftAddr := fmt.Sprintf("%s:%s", bindAddr, raftPort)
@@ -29,13 +33,19 @@ func StartNewServer(dataDir, nodeID, bindAddr string, raftPort string, httpPort
 	}
 
 	server := QueueServer{
-		RaftNode: raftNode,
+		RaftNode:     raftNode,
+		QueueManager: &queueManager,
+		MessageIDs:   ulid.NewGenerator(),
+		Tick:         tick,
 	}
 
 	mux := http.NewServeMux()
 	registerSystemRoutes(mux, &server)
 	registerQueueRoutes(mux, &server)
 
+	go server.runRedriveTasks()
+	go server.runTicker()
+
 	log.Printf("Starting SimplyQ server on port %s with Raft on %s...\n", httpPort, raftAddr)
 	log.Fatal(http.ListenAndServe(bindAddr+":"+httpPort, mux))
 }
@@ -51,5 +61,21 @@ func registerQueueRoutes(mux *http.ServeMux, server *QueueServer) {
 	mux.Handle("/sendMessage", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.sendMessageHandler)))
 	mux.Handle("/peekMessage", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.peekMessageHandler)))
 	mux.Handle("/popMessage", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.popMessageHandler)))
+	mux.Handle("/deleteMessage", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.deleteMessageHandler)))
+	mux.Handle("/changeMessageVisibility", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.changeMessageVisibilityHandler)))
+	mux.Handle("/nackMessage", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.nackMessageHandler)))
+	mux.Handle("/rejectMessage", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.rejectMessageHandler)))
 	mux.Handle("/viewAllMessages", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.viewQueueHandler)))
+	mux.Handle("/sendMessageBatch", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.sendMessageBatchHandler)))
+	mux.Handle("/receiveMessageBatch", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.receiveMessageBatchHandler)))
+	mux.Handle("/deleteMessageBatch", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.deleteMessageBatchHandler)))
+	mux.Handle("/scheduledMessages", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.listScheduledHandler)))
+	mux.Handle("/cancelScheduledMessage", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.cancelScheduledHandler)))
+	mux.Handle("/purgeQueue", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.purgeQueueHandler)))
+	mux.Handle("/deadLetterSourceQueues", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.listSourceQueuesHandler)))
+	mux.Handle("/startRedriveTask", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.startRedriveHandler)))
+	mux.Handle("/redriveTasks", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.listRedriveHandler)))
+	mux.Handle("/cancelRedriveTask", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.cancelRedriveHandler)))
+	mux.Handle("GET /queues/{name}/attributes", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.getAttributesHandler)))
+	mux.Handle("PUT /queues/{name}/attributes", server.LeaderRedirectMiddleWare(http.HandlerFunc(server.updateAttributesHandler)))
 }
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/Weile-Zheng/simplyQ/internal/queue_manager"
+)
+
+const (
+	DefaultTickInterval  = time.Second
+	DefaultTickBatchSize = 100
+)
+
+// TickConfig sets how often the leader ticks the queues and how many queues one TICK command covers.
+// Zero values fall back to DefaultTickInterval and DefaultTickBatchSize.
+type TickConfig struct {
+	Interval  time.Duration
+	BatchSize int
+}
+
+// runTicker proposes TICK commands while this node is the leader, so visibility timeouts, delays and retention
+// take effect on queues nobody sends to or receives from. The transitions happen when the TICK is applied,
+// at the time the leader stamped on it, so followers never change state on their own timers.
+func (s *QueueServer) runTicker() {
+	interval, batchSize := s.Tick.Interval, s.Tick.BatchSize
+	if interval <= 0 {
+		interval = DefaultTickInterval
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultTickBatchSize
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !s.RaftNode.IsLeader() {
+			continue
+		}
+		queueIDs := s.QueueManager.QueueIDs()
+		for start := 0; start < len(queueIDs); start += batchSize {
+			batch := queueIDs[start:min(start+batchSize, len(queueIDs))]
+			command := queue_manager.Command{
+				Type:      queue_manager.TICK,
+				Timestamp: time.Now(),
+				QueueIDs:  batch,
+			}
+			commandBytes, err := json.Marshal(command)
+			if err != nil {
+				log.Printf("Failed to marshal tick: %v", err)
+				break
+			}
+			if _, err := s.RaftNode.ApplyCommand(commandBytes, 5*time.Second); err != nil {
+				log.Printf("Failed to tick %d queues: %v", len(batch), err)
+				break
+			}
+		}
+	}
+}
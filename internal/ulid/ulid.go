@@ -0,0 +1,88 @@
+// Package ulid generates ULIDs, 26 character identifiers made of a 48 bit millisecond timestamp
+// followed by 80 random bits, both encoded in Crockford's base32. IDs sort by the time they were
+// generated, as strings and as bytes.
+package ulid
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+const encoding = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// maxTime is the last millisecond a ULID can encode, in the year 10889.
+const maxTime = 1<<48 - 1
+
+var errOverflow = errors.New("ulid: random component overflowed within one millisecond")
+
+// Generator hands out monotonic ULIDs. Within the same millisecond, or when the clock goes back,
+// the random part of the previous ID is incremented instead of drawn again, so every ID sorts
+// after the one before it.
+type Generator struct {
+	mu      sync.Mutex
+	entropy io.Reader
+	time    uint64
+	random  [10]byte
+}
+
+// NewGenerator creates a generator drawing randomness from crypto/rand.
+func NewGenerator() *Generator {
+	return &Generator{entropy: rand.Reader}
+}
+
+// New returns the next ULID for the time t.
+func (g *Generator) New(t time.Time) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(t.UnixMilli())
+	if ms > maxTime {
+		return "", errors.New("ulid: time is past the year 10889")
+	}
+	if ms <= g.time && g.time != 0 {
+		if !increment(&g.random) {
+			return "", errOverflow
+		}
+	} else {
+		if _, err := io.ReadFull(g.entropy, g.random[:]); err != nil {
+			return "", err
+		}
+		g.time = ms
+	}
+	return encode(g.time, g.random), nil
+}
+
+// increment adds one to the random part, reporting false when it wraps around.
+func increment(random *[10]byte) bool {
+	for i := len(random) - 1; i >= 0; i-- {
+		random[i]++
+		if random[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// encode writes the 128 bits five at a time, the first character only carries the top three bits of the time.
+func encode(ms uint64, random [10]byte) string {
+	var id [16]byte
+	for i := 0; i < 6; i++ {
+		id[i] = byte(ms >> (40 - 8*i))
+	}
+	copy(id[6:], random[:])
+
+	var text [26]byte
+	hi := uint64(id[0])<<56 | uint64(id[1])<<48 | uint64(id[2])<<40 | uint64(id[3])<<32 |
+		uint64(id[4])<<24 | uint64(id[5])<<16 | uint64(id[6])<<8 | uint64(id[7])
+	lo := uint64(id[8])<<56 | uint64(id[9])<<48 | uint64(id[10])<<40 | uint64(id[11])<<32 |
+		uint64(id[12])<<24 | uint64(id[13])<<16 | uint64(id[14])<<8 | uint64(id[15])
+	for i := 25; i >= 0; i-- {
+		text[i] = encoding[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(text[:])
+}
@@ -0,0 +1,119 @@
+package unit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Weile-Zheng/simplyQ/client"
+	"github.com/Weile-Zheng/simplyQ/internal/queue"
+)
+
+func TestClientCodesMatchQueue(t *testing.T) {
+	codes := map[client.Code]queue.Code{
+		client.OK:                           queue.OK,
+		client.EMPTY_QUEUE:                  queue.EMPTY_QUEUE,
+		client.EMPTY_DEAD_LETTER_QUEUE:      queue.EMPTY_DEAD_LETTER_QUEUE,
+		client.QUEUE_NOT_FOUND:              queue.QUEUE_NOT_FOUND,
+		client.QUEUE_ALREADY_EXISTS:         queue.QUEUE_ALREADY_EXISTS,
+		client.INVALID_RECEIPT_HANDLE:       queue.INVALID_RECEIPT_HANDLE,
+		client.MESSAGE_TOO_LARGE:            queue.MESSAGE_TOO_LARGE,
+		client.INVALID_QUEUE_CONFIG:         queue.INVALID_QUEUE_CONFIG,
+		client.INVALID_MESSAGE:              queue.INVALID_MESSAGE,
+		client.MESSAGE_NOT_FOUND:            queue.MESSAGE_NOT_FOUND,
+		client.INVALID_BATCH_SIZE:           queue.INVALID_BATCH_SIZE,
+		client.INVALID_VISIBILITY_TIMEOUT:   queue.INVALID_VISIBILITY_TIMEOUT,
+		client.DEAD_LETTER_TARGET_NOT_FOUND: queue.DEAD_LETTER_TARGET_NOT_FOUND,
+		client.QUEUE_IN_USE:                 queue.QUEUE_IN_USE,
+		client.INVALID_REDRIVE_TASK:         queue.INVALID_REDRIVE_TASK,
+		client.REDRIVE_TASK_NOT_FOUND:       queue.REDRIVE_TASK_NOT_FOUND,
+		client.INVALID_PURGE_TARGET:         queue.INVALID_PURGE_TARGET,
+		client.PURGE_IN_PROGRESS:            queue.PURGE_IN_PROGRESS,
+	}
+	for clientCode, queueCode := range codes {
+		if int(clientCode) != int(queueCode) {
+			t.Errorf("Expected client code %d to equal queue code %d", clientCode, queueCode)
+		}
+	}
+
+	targets := map[client.PurgeTarget]queue.PurgeTarget{
+		client.PurgeMessages:    queue.PurgeMessages,
+		client.PurgeDeadLetters: queue.PurgeDeadLetters,
+		client.PurgeAll:         queue.PurgeAll,
+	}
+	for clientTarget, queueTarget := range targets {
+		if string(clientTarget) != string(queueTarget) {
+			t.Errorf("Expected purge target %q to equal %q", clientTarget, queueTarget)
+		}
+	}
+	if client.PurgeCooldown != queue.PurgeCooldown {
+		t.Errorf("Expected purge cooldown %v, got %v", queue.PurgeCooldown, client.PurgeCooldown)
+	}
+}
+
+// visibilityServer answers changeMessageVisibility requests with code and counts them.
+func visibilityServer(t *testing.T, code client.Code, extensions *atomic.Int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/changeMessageVisibility" || query.Get("receiptHandle") != "handle-1" || query.Get("visibilityTimeout") != "30" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+		}
+		extensions.Add(1)
+		fmt.Fprintf(w, `{"code":%d}`, code)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHeartbeat(t *testing.T) {
+	var extensions atomic.Int32
+	server := visibilityServer(t, client.OK, &extensions)
+	c := client.New(server.URL)
+	c.HeartbeatInterval = 5 * time.Millisecond
+
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Heartbeat(ctx, "TestQueue", "handle-1", 30*time.Second)
+	}()
+
+	// The visibility keeps being extended while the message is processed
+	deadline := time.Now().Add(5 * time.Second)
+	for extensions.Load() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected repeated extensions, got %d", extensions.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	stop()
+	if err := <-done; err != nil {
+		t.Errorf("Expected nil once the context is cancelled, got %v", err)
+	}
+	stopped := extensions.Load()
+	time.Sleep(20 * time.Millisecond)
+	if extensions.Load() != stopped {
+		t.Errorf("Expected no extensions after the context is cancelled, got %d more", extensions.Load()-stopped)
+	}
+}
+
+func TestHeartbeatStopsOnError(t *testing.T) {
+	var extensions atomic.Int32
+	server := visibilityServer(t, client.INVALID_RECEIPT_HANDLE, &extensions)
+	c := client.New(server.URL)
+	c.HeartbeatInterval = time.Millisecond
+
+	err := c.Heartbeat(context.Background(), "TestQueue", "handle-1", 30*time.Second)
+	var clientErr *client.Error
+	if !errors.As(err, &clientErr) || clientErr.Code != client.INVALID_RECEIPT_HANDLE {
+		t.Errorf("Expected INVALID_RECEIPT_HANDLE, got %v", err)
+	}
+	if extensions.Load() != 1 {
+		t.Errorf("Expected the heartbeat to stop after the first failure, got %d extensions", extensions.Load())
+	}
+}
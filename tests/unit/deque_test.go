@@ -0,0 +1,122 @@
+package unit_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Weile-Zheng/simplyQ/internal/deque"
+	"github.com/Weile-Zheng/simplyQ/internal/queue"
+)
+
+func TestDeque(t *testing.T) {
+	var d deque.Deque[int]
+
+	// Enough elements to span several chunks, pushed from both ends
+	for i := 0; i < 1000; i++ {
+		d.PushBack(i)
+	}
+	for i := -1; i >= -300; i-- {
+		d.PushFront(i)
+	}
+	if d.Len() != 1300 {
+		t.Fatalf("Expected 1300 elements, got %d", d.Len())
+	}
+	if d.Front() != -300 || d.At(0) != -300 || d.At(1299) != 999 {
+		t.Errorf("Expected elements from -300 to 999, got front %d and back %d", d.At(0), d.At(1299))
+	}
+
+	d.Set(500, 42)
+	if d.At(500) != 42 {
+		t.Errorf("Expected 42 after Set, got %d", d.At(500))
+	}
+	d.Set(500, 200)
+
+	for want := -300; want < 1000; want++ {
+		if got := d.PopFront(); got != want {
+			t.Fatalf("Expected %d, got %d", want, got)
+		}
+	}
+	if d.Len() != 0 {
+		t.Errorf("Expected an empty deque, got %d elements", d.Len())
+	}
+
+	// A drained deque is reused from both ends
+	d.PushFront(1)
+	d.PushBack(2)
+	d.PushFront(0)
+	for want := 0; want < 3; want++ {
+		if got := d.PopFront(); got != want {
+			t.Errorf("Expected %d, got %d", want, got)
+		}
+	}
+}
+
+func TestDequeIndexOutOfRange(t *testing.T) {
+	var d deque.Deque[int]
+	d.PushBack(1)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected At past the last element to panic")
+		}
+	}()
+	d.At(1)
+}
+
+// The benchmarks compare the deque with the slice the ready messages used to be kept in,
+// appended to at the back and resliced at the front.
+
+func benchmarkSlice(b *testing.B, backlog int) {
+	b.ReportAllocs()
+	var messages []queue.Message
+	for i := 0; i < backlog; i++ {
+		messages = append(messages, queue.Message{SequenceNumber: uint64(i)})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		messages = append(messages, queue.Message{SequenceNumber: uint64(i)})
+		messages = messages[1:]
+	}
+}
+
+func benchmarkDeque(b *testing.B, backlog int) {
+	b.ReportAllocs()
+	var messages deque.Deque[queue.Message]
+	for i := 0; i < backlog; i++ {
+		messages.PushBack(queue.Message{SequenceNumber: uint64(i)})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		messages.PushBack(queue.Message{SequenceNumber: uint64(i)})
+		messages.PopFront()
+	}
+}
+
+func BenchmarkReadySlice(b *testing.B) {
+	for _, backlog := range []int{10, 10_000} {
+		b.Run(fmt.Sprintf("backlog=%d", backlog), func(b *testing.B) { benchmarkSlice(b, backlog) })
+	}
+}
+
+func BenchmarkReadyDeque(b *testing.B) {
+	for _, backlog := range []int{10, 10_000} {
+		b.Run(fmt.Sprintf("backlog=%d", backlog), func(b *testing.B) { benchmarkDeque(b, backlog) })
+	}
+}
+
+// BenchmarkSendReceiveDelete runs a message through the queue goroutine end to end.
+func BenchmarkSendReceiveDelete(b *testing.B) {
+	standard := config
+	standard.Type = queue.QueueTypeStandard
+	queueIO := queue.MakeQueue("id", standard)
+	defer queueIO.Close()
+
+	b.ReportAllocs()
+	now := time.Now()
+	for i := 0; i < b.N; i++ {
+		queueIO.InsertQueue(now, queue.Message{ID: fmt.Sprint(i)})
+		response := queueIO.PeekQueue(now)
+		queueIO.RemoveQueue(now, response.ReceiptHandle)
+	}
+}
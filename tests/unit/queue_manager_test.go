@@ -1,6 +1,8 @@
 package unit_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"sync"
 	"testing"
@@ -114,17 +116,17 @@ func TestSendMessage(t *testing.T) {
 	queueID := queueConfig.Name
 	message := queue.Message{
 		ID:   "msg-1",
-		Body: "Test message",
+		Body: []byte("Test message"),
 	}
 
 	// Test successful message send
-	response := qm.SendMessage(queueID, message)
+	response := qm.SendMessage(time.Now(), queueID, message)
 	if response.Code != queue.OK {
 		t.Errorf("Expected OK, got %v", response.Code)
 	}
 
 	// Test sending to non-existent queue
-	response = qm.SendMessage("non-existent", message)
+	response = qm.SendMessage(time.Now(), "non-existent", message)
 	if response.Code != queue.QUEUE_NOT_FOUND {
 		t.Errorf("Expected QUEUE_NOT_FOUND, got %v", response.Code)
 	}
@@ -149,7 +151,7 @@ func TestPeekMessage(t *testing.T) {
 	qm.CreateQueue(queueConfig)
 	queueID := queueConfig.Name
 	// Test peek on empty queue
-	response := qm.PeekMessage(queueID)
+	response := qm.PeekMessage(time.Now(), queueID)
 	if response.Code != queue.EMPTY_QUEUE {
 		t.Errorf("Expected EMPTY_QUEUE, got %v", response.Code)
 	}
@@ -157,12 +159,12 @@ func TestPeekMessage(t *testing.T) {
 	// Send a message first
 	message := queue.Message{
 		ID:   "msg-1",
-		Body: "Test message",
+		Body: []byte("Test message"),
 	}
-	qm.SendMessage(queueID, message)
+	qm.SendMessage(time.Now(), queueID, message)
 
 	// Test successful peek
-	response = qm.PeekMessage(queueID)
+	response = qm.PeekMessage(time.Now(), queueID)
 	if response.Code != queue.OK {
 		t.Errorf("Expected OK, got %v", response.Code)
 	}
@@ -171,7 +173,7 @@ func TestPeekMessage(t *testing.T) {
 	}
 
 	// Test peek on non-existent queue
-	response = qm.PeekMessage("non-existent")
+	response = qm.PeekMessage(time.Now(), "non-existent")
 	if response.Code != queue.QUEUE_NOT_FOUND {
 		t.Errorf("Expected QUEUE_NOT_FOUND, got %v", response.Code)
 	}
@@ -195,33 +197,34 @@ func TestDeleteMessage(t *testing.T) {
 	// Create a queue
 	qm.CreateQueue(queueConfig)
 	queueID := queueConfig.Name
-	// Test delete on empty queue
-	response := qm.PopMessage(queueID)
-	if response.Code != queue.EMPTY_QUEUE {
-		t.Errorf("Expected EMPTY_QUEUE, got %v", response.Code)
+	// Test delete with an unknown receipt handle
+	response := qm.PopMessage(time.Now(), queueID, "unknown")
+	if response.Code != queue.INVALID_RECEIPT_HANDLE {
+		t.Errorf("Expected INVALID_RECEIPT_HANDLE, got %v", response.Code)
 	}
 
 	// Send a message first
 	message := queue.Message{
 		ID:   "msg-1",
-		Body: "Test message",
+		Body: []byte("Test message"),
 	}
-	qm.SendMessage(queueID, message)
+	qm.SendMessage(time.Now(), queueID, message)
 
 	// Test successful delete
-	response = qm.PopMessage(queueID)
+	response = qm.PeekMessage(time.Now(), queueID)
+	response = qm.PopMessage(time.Now(), queueID, response.ReceiptHandle)
 	if response.Code != queue.OK {
 		t.Errorf("Expected OK, got %v", response.Code)
 	}
 
 	// Verify message is deleted
-	peekResponse := qm.PeekMessage(queueID)
+	peekResponse := qm.PeekMessage(time.Now(), queueID)
 	if peekResponse.Code != queue.EMPTY_QUEUE {
 		t.Errorf("Expected EMPTY_QUEUE after delete, got %v", peekResponse.Code)
 	}
 
 	// Test delete on non-existent queue
-	response = qm.PopMessage("non-existent")
+	response = qm.PopMessage(time.Now(), "non-existent", response.ReceiptHandle)
 	if response.Code != queue.QUEUE_NOT_FOUND {
 		t.Errorf("Expected QUEUE_NOT_FOUND, got %v", response.Code)
 	}
@@ -260,10 +263,10 @@ func TestMultipleQueues(t *testing.T) {
 		queueID := fmt.Sprintf("TestQueue%d", i)
 		message := queue.Message{
 			ID:   fmt.Sprintf("msg-%d", i),
-			Body: fmt.Sprintf("Message %d", i),
+			Body: []byte(fmt.Sprintf("Message %d", i)),
 		}
 
-		response := qm.SendMessage(queueID, message)
+		response := qm.SendMessage(time.Now(), queueID, message)
 		if response.Code != queue.OK {
 			t.Errorf("Failed to send message to queue %d", i)
 		}
@@ -272,7 +275,7 @@ func TestMultipleQueues(t *testing.T) {
 	// Verify messages in different queues
 	for i := 0; i < 3; i++ {
 		queueID := fmt.Sprintf("TestQueue%d", i)
-		response := qm.PeekMessage(queueID)
+		response := qm.PeekMessage(time.Now(), queueID)
 		if response.Code != queue.OK {
 			t.Errorf("Failed to peek message from queue %d", i)
 		}
@@ -311,9 +314,9 @@ func TestConcurrentQueueOperations(t *testing.T) {
 			defer wg.Done()
 			message := queue.Message{
 				ID:   fmt.Sprintf("concurrent-msg-%d", id),
-				Body: fmt.Sprintf("Concurrent message %d", id),
+				Body: []byte(fmt.Sprintf("Concurrent message %d", id)),
 			}
-			response := qm.SendMessage(queueID, message)
+			response := qm.SendMessage(time.Now(), queueID, message)
 			if response.Code != queue.OK {
 				t.Errorf("Failed to send concurrent message %d", id)
 			}
@@ -325,12 +328,12 @@ func TestConcurrentQueueOperations(t *testing.T) {
 	// Verify messages were sent
 	messageCount := 0
 	for {
-		response := qm.PeekMessage(queueID)
+		response := qm.PeekMessage(time.Now(), queueID)
 		if response.Code == queue.EMPTY_QUEUE {
 			break
 		}
 		messageCount++
-		deleteResponse := qm.PopMessage(queueID)
+		deleteResponse := qm.PopMessage(time.Now(), queueID, response.ReceiptHandle)
 		if deleteResponse.Code != queue.OK {
 			t.Errorf("Failed to delete message %d with %v", messageCount, deleteResponse.Code)
 		}
@@ -432,13 +435,14 @@ func TestMixedConcurrentOperations(t *testing.T) {
 			case 0: // Send message
 				message := queue.Message{
 					ID:   fmt.Sprintf("mixed-msg-%d", id),
-					Body: fmt.Sprintf("Mixed message %d", id),
+					Body: []byte(fmt.Sprintf("Mixed message %d", id)),
 				}
-				qm.SendMessage(queueID, message)
+				qm.SendMessage(time.Now(), queueID, message)
 			case 1: // Peek message
-				qm.PeekMessage(queueID)
-			case 2: // Delete message
-				qm.PopMessage(queueID)
+				qm.PeekMessage(time.Now(), queueID)
+			case 2: // Receive and delete message
+				response := qm.PeekMessage(time.Now(), queueID)
+				qm.PopMessage(time.Now(), queueID, response.ReceiptHandle)
 			case 3: // Create new queue (might fail if exists)
 				newQueueConfig := queue.QueueConfig{
 					Name:              fmt.Sprintf("NewMixedQueue%d", id),
@@ -470,3 +474,506 @@ func TestMixedConcurrentOperations(t *testing.T) {
 		t.Error("Queue manager not functional after mixed concurrent operations")
 	}
 }
+
+func TestRestoreAllQueues(t *testing.T) {
+	qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+
+	queueConfig := queue.QueueConfig{
+		Name:              "TestQueue",
+		Type:              queue.QueueTypeFIFO,
+		RetentionPeriod:   time.Hour,
+		VisibilityTimeout: time.Minute,
+		MaxReceiveCount:   3,
+		MaxMessageSize:    1024,
+	}
+	qm.CreateQueue(queueConfig)
+	qm.SendMessage(time.Now(), queueConfig.Name, queue.Message{ID: "msg-1", Body: []byte("In flight"), DeduplicationID: "dedup-1"})
+	qm.SendMessage(time.Now(), queueConfig.Name, queue.Message{ID: "msg-2", Body: []byte("Waiting")})
+	received := qm.PeekMessage(time.Now(), queueConfig.Name)
+
+	// Restore the snapshot on another manager, as a follower does after installing a Raft snapshot
+	restored := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "Restored"})
+	restored.RestoreAllQueues(qm.ViewAllQueues())
+
+	if len(restored.Queues) != 1 {
+		t.Fatalf("Expected 1 restored queue, got %d", len(restored.Queues))
+	}
+
+	// The deduplication table survives the restore
+	response := restored.SendMessage(time.Now(), queueConfig.Name, queue.Message{ID: "msg-3", Body: []byte("Retry"), DeduplicationID: "dedup-1"})
+	if response.Message.ID != "msg-1" {
+		t.Errorf("Expected the original msg-1 for a duplicate send, got %s", response.Message.ID)
+	}
+
+	// So does the in flight message and its receipt handle
+	if response := restored.PeekMessage(time.Now(), queueConfig.Name); response.Code != queue.EMPTY_QUEUE {
+		t.Errorf("Expected EMPTY_QUEUE while msg-1 is in flight, got %v with %s", response.Code, response.Message.ID)
+	}
+	if response := restored.PopMessage(time.Now(), queueConfig.Name, received.ReceiptHandle); response.Code != queue.OK {
+		t.Errorf("Expected OK deleting the restored in flight message, got %v", response.Code)
+	}
+	if response := restored.PeekMessage(time.Now(), queueConfig.Name); response.Message.ID != "msg-2" {
+		t.Errorf("Expected msg-2, got %s", response.Message.ID)
+	}
+}
+
+func TestViewAllMessagesDelayed(t *testing.T) {
+	qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+
+	queueConfig := queue.QueueConfig{
+		Name:              "DelayQueue",
+		Type:              queue.QueueTypeStandard,
+		RetentionPeriod:   time.Hour,
+		VisibilityTimeout: time.Minute,
+		DelaySeconds:      60,
+	}
+	qm.CreateQueue(queueConfig)
+
+	// The queue default delay applies unless the message sets its own
+	qm.SendMessage(time.Now(), queueConfig.Name, queue.Message{ID: "msg-1", Body: []byte("Delayed by the queue")})
+
+	view := qm.ViewAllMessages(queueConfig.Name)
+	if view.Code != queue.OK {
+		t.Fatalf("Expected OK, got %v", view.Code)
+	}
+	if len(view.Messages) != 0 || len(view.Delayed) != 1 {
+		t.Errorf("Expected 0 ready and 1 delayed message, got %d and %d", len(view.Messages), len(view.Delayed))
+	}
+	if response := qm.PeekMessage(time.Now(), queueConfig.Name); response.Code != queue.EMPTY_QUEUE {
+		t.Errorf("Expected EMPTY_QUEUE while the message is delayed, got %v", response.Code)
+	}
+
+	if view := qm.ViewAllMessages("non-existent"); view.Code != queue.QUEUE_NOT_FOUND {
+		t.Errorf("Expected QUEUE_NOT_FOUND, got %v", view.Code)
+	}
+}
+
+func TestScheduledMessagesSurviveRestore(t *testing.T) {
+	qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+
+	queueConfig := queue.QueueConfig{
+		Name:            "Billing",
+		Type:            queue.QueueTypeStandard,
+		RetentionPeriod: 7 * 24 * time.Hour,
+	}
+	qm.CreateQueue(queueConfig)
+
+	deliverAt := time.Now().Add(72 * time.Hour)
+	qm.SendMessage(time.Now(), queueConfig.Name, queue.Message{ID: "reminder-1", Body: []byte("Reminder"), DeliverAt: deliverAt})
+	qm.SendMessage(time.Now(), queueConfig.Name, queue.Message{ID: "reminder-2", Body: []byte("Reminder"), DeliverAt: deliverAt.Add(time.Hour)})
+
+	restored := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "Restored"})
+	restored.RestoreAllQueues(qm.ViewAllQueues())
+
+	view := restored.ListScheduledMessages(queueConfig.Name)
+	if view.Code != queue.OK || len(view.Scheduled) != 2 {
+		t.Fatalf("Expected 2 scheduled messages after restore, got %v with %d", view.Code, len(view.Scheduled))
+	}
+	if !view.Scheduled[0].DueAt.Equal(deliverAt) {
+		t.Errorf("Expected reminder-1 due at %v, got %v", deliverAt, view.Scheduled[0].DueAt)
+	}
+
+	if response := restored.CancelScheduledMessage(time.Now(), queueConfig.Name, "reminder-1"); response.Code != queue.OK {
+		t.Errorf("Expected OK cancelling reminder-1, got %v", response.Code)
+	}
+	if view := restored.ListScheduledMessages(queueConfig.Name); len(view.Scheduled) != 1 || view.Scheduled[0].Message.ID != "reminder-2" {
+		t.Errorf("Expected only reminder-2 scheduled, got %v", view.Scheduled)
+	}
+	if response := restored.CancelScheduledMessage(time.Now(), "non-existent", "reminder-2"); response.Code != queue.QUEUE_NOT_FOUND {
+		t.Errorf("Expected QUEUE_NOT_FOUND, got %v", response.Code)
+	}
+}
+
+func TestBinaryBodySurvivesSnapshot(t *testing.T) {
+	qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+	qm.CreateQueue(queue.QueueConfig{Name: "Binary", Type: queue.QueueTypeStandard})
+
+	body := []byte{0x00, 0x80, 0xff, 0xc3, 0x28}
+	qm.SendMessage(time.Now(), "Binary", queue.Message{ID: "msg-1", Body: body, ContentType: "application/octet-stream"})
+
+	// Snapshots are persisted as JSON, like the Raft FSM does
+	data, err := json.Marshal(qm.ViewAllQueues())
+	if err != nil {
+		t.Fatalf("Failed to marshal snapshot: %v", err)
+	}
+	var queues map[string]queue.Queue
+	if err := json.Unmarshal(data, &queues); err != nil {
+		t.Fatalf("Failed to unmarshal snapshot: %v", err)
+	}
+
+	restored := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "Restored"})
+	restored.RestoreAllQueues(queues)
+
+	response := restored.PeekMessage(time.Now(), "Binary")
+	if !bytes.Equal(response.Message.Body, body) {
+		t.Errorf("Expected body %v after restore, got %v", body, response.Message.Body)
+	}
+	if response.Message.ContentType != "application/octet-stream" {
+		t.Errorf("Expected the content type to survive, got %s", response.Message.ContentType)
+	}
+}
+
+func TestWaitForMessageDoesNotHoldLock(t *testing.T) {
+	qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+	qm.CreateQueue(queue.QueueConfig{Name: "LongPoll", Type: queue.QueueTypeStandard, VisibilityTimeout: time.Minute})
+
+	if wake := qm.WaitForMessage("non-existent", time.Now().Add(time.Second)); wake != nil {
+		t.Error("Expected no waiter for a non-existent queue")
+	}
+
+	wake := qm.WaitForMessage("LongPoll", time.Now().Add(time.Second))
+
+	// Creating a queue takes the write lock, it must not be blocked by the waiting receiver
+	done := make(chan queue.Code)
+	go func() {
+		done <- qm.CreateQueue(queue.QueueConfig{Name: "Other", Type: queue.QueueTypeStandard})
+	}()
+	select {
+	case code := <-done:
+		if code != queue.OK {
+			t.Errorf("Expected OK, got %v", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected CreateQueue to finish while a receiver is waiting")
+	}
+
+	qm.SendMessage(time.Now(), "LongPoll", queue.Message{ID: "msg-1", Body: []byte("Wake up")})
+	select {
+	case <-wake:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the waiter to be woken by SendMessage")
+	}
+}
+
+func TestRedrivePolicy(t *testing.T) {
+	now := time.Now()
+	qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+
+	deadLetterConfig := queue.QueueConfig{
+		Name:            "DeadLetters",
+		Type:            queue.QueueTypeStandard,
+		RetentionPeriod: time.Hour,
+	}
+	sourceConfig := queue.QueueConfig{
+		Name:              "Source",
+		Type:              queue.QueueTypeStandard,
+		RetentionPeriod:   time.Hour,
+		VisibilityTimeout: 50 * time.Millisecond,
+		RedrivePolicy:     queue.RedrivePolicy{DeadLetterTargetQueue: deadLetterConfig.Name, MaxReceiveCount: 1},
+	}
+
+	// The target has to exist before a queue can dead letter to it
+	if code := qm.CreateQueue(sourceConfig); code != queue.DEAD_LETTER_TARGET_NOT_FOUND {
+		t.Errorf("Expected DEAD_LETTER_TARGET_NOT_FOUND, got %v", code)
+	}
+	qm.CreateQueue(deadLetterConfig)
+	invalid := sourceConfig
+	invalid.RedrivePolicy.MaxReceiveCount = 0
+	if code := qm.CreateQueue(invalid); code != queue.INVALID_QUEUE_CONFIG {
+		t.Errorf("Expected INVALID_QUEUE_CONFIG without a MaxReceiveCount, got %v", code)
+	}
+	if code := qm.CreateQueue(sourceConfig); code != queue.OK {
+		t.Fatalf("Expected OK, got %v", code)
+	}
+
+	qm.SendMessage(now, sourceConfig.Name, queue.Message{ID: "msg-1", Body: []byte("Poison")})
+	if response := qm.PeekMessage(now, sourceConfig.Name); response.Message.ID != "msg-1" {
+		t.Fatalf("Expected msg-1, got %s", response.Message.ID)
+	}
+	now = now.Add(2 * sourceConfig.VisibilityTimeout)
+
+	// The second receive exceeds the policy and moves the message to the target queue
+	if response := qm.PeekMessage(now, sourceConfig.Name); response.Code != queue.EMPTY_QUEUE {
+		t.Errorf("Expected EMPTY_QUEUE, got %v with %s", response.Code, response.Message.ID)
+	}
+	response := qm.PeekMessage(now, deadLetterConfig.Name)
+	if response.Message.ID != "msg-1" || response.Message.ReceiveCount != 1 {
+		t.Errorf("Expected msg-1 received once from the dead letter queue, got %s received %d times", response.Message.ID, response.Message.ReceiveCount)
+	}
+
+	view := qm.ListSourceQueues(deadLetterConfig.Name)
+	if view.Code != queue.OK || len(view.SourceQueues) != 1 || view.SourceQueues[0] != sourceConfig.Name {
+		t.Errorf("Expected Source as the only source queue, got %v", view.SourceQueues)
+	}
+
+	// The target can not be deleted while it is in use, also after a restore
+	restored := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "Restored"})
+	restored.RestoreAllQueues(qm.ViewAllQueues())
+	if code := restored.DeleteQueue(deadLetterConfig.Name); code != queue.QUEUE_IN_USE {
+		t.Errorf("Expected QUEUE_IN_USE, got %v", code)
+	}
+	if code := restored.DeleteQueue(sourceConfig.Name); code != queue.OK {
+		t.Errorf("Expected OK deleting the source queue, got %v", code)
+	}
+	if code := restored.DeleteQueue(deadLetterConfig.Name); code != queue.OK {
+		t.Errorf("Expected OK deleting the unused dead letter queue, got %v", code)
+	}
+}
+
+func TestRedriveTasks(t *testing.T) {
+	now := time.Now()
+	qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+
+	queueConfig := queue.QueueConfig{
+		Name:              "Work",
+		Type:              queue.QueueTypeStandard,
+		RetentionPeriod:   time.Hour,
+		VisibilityTimeout: 50 * time.Millisecond,
+		MaxReceiveCount:   1,
+	}
+	qm.CreateQueue(queueConfig)
+	for i := 1; i <= 3; i++ {
+		qm.SendMessage(now, queueConfig.Name, queue.Message{ID: fmt.Sprintf("msg-%d", i), Body: []byte("Failing job")})
+		qm.PeekMessage(now, queueConfig.Name)
+	}
+	now = now.Add(2 * queueConfig.VisibilityTimeout)
+	qm.PeekMessage(now, queueConfig.Name) // dead letters all three messages.
+
+	if view := qm.StartRedriveTask(queue_manager.RedriveTask{ID: "bad", DeadLetterQueue: queueConfig.Name, MaxMessagesPerSecond: queue_manager.MaxRedriveRate + 1}); view.Code != queue.INVALID_REDRIVE_TASK {
+		t.Errorf("Expected INVALID_REDRIVE_TASK for a rate above the limit, got %v", view.Code)
+	}
+
+	view := qm.StartRedriveTask(queue_manager.RedriveTask{
+		ID:                   "task-1",
+		DeadLetterQueue:      queueConfig.Name,
+		Selector:             queue.MessageSelector{MessageIDs: []string{"msg-1", "msg-2"}},
+		MaxMessagesPerSecond: 1,
+	})
+	if view.Code != queue.OK || view.Tasks[0].DestinationQueue != queueConfig.Name {
+		t.Fatalf("Expected the task to redrive to its own queue, got %v with %v", view.Code, view.Tasks)
+	}
+	if view := qm.StartRedriveTask(queue_manager.RedriveTask{ID: "task-1", DeadLetterQueue: queueConfig.Name}); view.Code != queue.INVALID_REDRIVE_TASK {
+		t.Errorf("Expected INVALID_REDRIVE_TASK for a duplicate ID, got %v", view.Code)
+	}
+	if view := qm.StepRedriveTask(now, "task-1"); view.Tasks[0].MovedMessages != 1 || view.Tasks[0].Status != queue_manager.RedriveRunning {
+		t.Errorf("Expected 1 message moved by a running task, got %v", view.Tasks[0])
+	}
+
+	// The task continues on a restored manager, as it does on a new leader
+	restored := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "Restored"})
+	restored.RestoreAllQueues(qm.ViewAllQueues())
+	restored.RestoreRedriveTasks(qm.ListRedriveTasks("").Tasks)
+	if running := restored.RunningRedriveTasks(); len(running) != 1 || running[0] != "task-1" {
+		t.Fatalf("Expected task-1 to be running after the restore, got %v", running)
+	}
+	restored.StepRedriveTask(now, "task-1")
+	view = restored.StepRedriveTask(now, "task-1")
+	if view.Tasks[0].MovedMessages != 2 || view.Tasks[0].Status != queue_manager.RedriveCompleted {
+		t.Errorf("Expected the task completed after moving 2 messages, got %v", view.Tasks[0])
+	}
+
+	snapshot := restored.ViewAllQueues()[queueConfig.Name]
+	if len(snapshot.Messages) != 2 || len(snapshot.DeadLetterQueue) != 1 || snapshot.DeadLetterQueue[0].ID != "msg-3" {
+		t.Errorf("Expected 2 redriven messages and msg-3 left dead lettered, got %d and %d", len(snapshot.Messages), len(snapshot.DeadLetterQueue))
+	}
+	if response := restored.PeekMessage(now, queueConfig.Name); response.Code != queue.OK || response.Message.ReceiveCount != 1 {
+		t.Errorf("Expected a redriven message to be received again, got %v", response.Code)
+	}
+
+	// A cancelled task is not stepped anymore
+	restored.StartRedriveTask(queue_manager.RedriveTask{ID: "task-2", DeadLetterQueue: queueConfig.Name})
+	if view := restored.CancelRedriveTask("task-2"); view.Tasks[0].Status != queue_manager.RedriveCancelled {
+		t.Errorf("Expected the task to be cancelled, got %v", view.Tasks[0].Status)
+	}
+	if view := restored.StepRedriveTask(now, "task-2"); view.Tasks[0].MovedMessages != 0 {
+		t.Errorf("Expected a cancelled task to move nothing, got %d", view.Tasks[0].MovedMessages)
+	}
+	if view := restored.CancelRedriveTask("unknown"); view.Code != queue.REDRIVE_TASK_NOT_FOUND {
+		t.Errorf("Expected REDRIVE_TASK_NOT_FOUND, got %v", view.Code)
+	}
+}
+
+func TestRedriveTaskFromTargetQueue(t *testing.T) {
+	now := time.Now()
+	qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+
+	qm.CreateQueue(queue.QueueConfig{Name: "DeadLetters", Type: queue.QueueTypeStandard})
+	qm.CreateQueue(queue.QueueConfig{
+		Name:              "Source",
+		Type:              queue.QueueTypeStandard,
+		VisibilityTimeout: 50 * time.Millisecond,
+		RedrivePolicy:     queue.RedrivePolicy{DeadLetterTargetQueue: "DeadLetters", MaxReceiveCount: 1},
+	})
+	qm.SendMessage(now, "Source", queue.Message{ID: "msg-1", Body: []byte("Poison")})
+	qm.PeekMessage(now, "Source")
+	now = now.Add(100 * time.Millisecond)
+	qm.PeekMessage(now, "Source")
+
+	// The destination defaults to the only source queue of the target
+	view := qm.StartRedriveTask(queue_manager.RedriveTask{ID: "task-1", DeadLetterQueue: "DeadLetters"})
+	if view.Code != queue.OK || view.Tasks[0].DestinationQueue != "Source" {
+		t.Fatalf("Expected the task to redrive to Source, got %v with %v", view.Code, view.Tasks)
+	}
+	if view := qm.StepRedriveTask(now, "task-1"); view.Tasks[0].MovedMessages != 1 || view.Tasks[0].Status != queue_manager.RedriveCompleted {
+		t.Errorf("Expected the task completed after moving 1 message, got %v", view.Tasks[0])
+	}
+	if response := qm.PeekMessage(now, "Source"); response.Message.ID != "msg-1" {
+		t.Errorf("Expected msg-1 back in Source, got %s", response.Message.ID)
+	}
+}
+
+func TestExpiredMessagesAreDeadLettered(t *testing.T) {
+	now := time.Now()
+	qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+
+	qm.CreateQueue(queue.QueueConfig{Name: "DeadLetters", Type: queue.QueueTypeStandard, RetentionPeriod: time.Hour})
+	qm.CreateQueue(queue.QueueConfig{
+		Name:            "Source",
+		Type:            queue.QueueTypeStandard,
+		RetentionPeriod: 50 * time.Millisecond,
+		RedrivePolicy:   queue.RedrivePolicy{DeadLetterTargetQueue: "DeadLetters", MaxReceiveCount: 3},
+	})
+
+	qm.SendMessage(now, "Source", queue.Message{ID: "msg-1", Body: []byte("Never received")})
+	now = now.Add(100 * time.Millisecond)
+
+	// Any request on the source expires the message and moves it to the target
+	qm.SendMessage(now, "Source", queue.Message{ID: "msg-2", Body: []byte("Fresh")})
+	view := qm.ViewAllMessages("DeadLetters")
+	if len(view.Messages) != 1 || view.Messages[0].DeadLetter == nil {
+		t.Fatalf("Expected 1 dead letter in the target, got %d", len(view.Messages))
+	}
+	if info := view.Messages[0].DeadLetter; info.Reason != queue.DeadLetterExpired || info.SourceQueue != "Source" {
+		t.Errorf("Expected msg-1 expired from Source, got %+v", info)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestUpdateQueueConfig(t *testing.T) {
+	qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+
+	qm.CreateQueue(queue.QueueConfig{Name: "A", Type: queue.QueueTypeStandard})
+	qm.CreateQueue(queue.QueueConfig{Name: "B", Type: queue.QueueTypeStandard})
+	qm.CreateQueue(queue.QueueConfig{
+		Name:          "C",
+		Type:          queue.QueueTypeStandard,
+		RedrivePolicy: queue.RedrivePolicy{DeadLetterTargetQueue: "B", MaxReceiveCount: 1},
+	})
+
+	// Only the fields in the update change
+	qm.UpdateQueueConfig(time.Now(), "A", queue.ConfigUpdate{RetentionPeriod: ptr(time.Hour)})
+	view := qm.UpdateQueueConfig(time.Now(), "A", queue.ConfigUpdate{
+		VisibilityTimeout: ptr(time.Minute),
+		MaxMessageSize:    ptr(uint32(8)),
+	})
+	if view.Code != queue.OK || view.QueueConfig.VisibilityTimeout != time.Minute || view.QueueConfig.RetentionPeriod != time.Hour {
+		t.Fatalf("Expected the update to apply and keep the retention period, got %v %+v", view.Code, view.QueueConfig)
+	}
+	if response := qm.SendMessage(time.Now(), "A", queue.Message{ID: "msg-1", Body: []byte("Now too large")}); response.Code != queue.MESSAGE_TOO_LARGE {
+		t.Errorf("Expected MESSAGE_TOO_LARGE with the new limit, got %v", response.Code)
+	}
+
+	// A configuration read from the queue can be sent back, as long as the name and type stay
+	config := qm.GetQueueConfig(time.Now(), "A").QueueConfig
+	if view := qm.UpdateQueueConfig(time.Now(), "A", queue.ConfigUpdate{Name: &config.Name, Type: &config.Type}); view.Code != queue.OK {
+		t.Errorf("Expected OK sending the current name and type, got %v", view.Code)
+	}
+	if view := qm.UpdateQueueConfig(time.Now(), "A", queue.ConfigUpdate{Type: ptr(queue.QueueTypeFIFO)}); view.Code != queue.INVALID_QUEUE_CONFIG {
+		t.Errorf("Expected INVALID_QUEUE_CONFIG changing the type, got %v", view.Code)
+	}
+	if got := qm.GetQueueConfig(time.Now(), "A").QueueConfig; got.Type != queue.QueueTypeStandard || got.MaxMessageSize != 8 {
+		t.Errorf("Expected the rejected update to leave the config unchanged, got %+v", got)
+	}
+
+	// A -> C -> B is fine, B -> A would close the cycle B -> A -> C -> B
+	if view := qm.UpdateQueueConfig(time.Now(), "A", queue.ConfigUpdate{
+		RedrivePolicy: &queue.RedrivePolicy{DeadLetterTargetQueue: "C", MaxReceiveCount: 3},
+	}); view.Code != queue.OK {
+		t.Errorf("Expected OK adding a redrive policy, got %v", view.Code)
+	}
+	if sources := qm.ListSourceQueues("C").SourceQueues; len(sources) != 1 || sources[0] != "A" {
+		t.Errorf("Expected A as the source of C, got %v", sources)
+	}
+	redrive := queue.ConfigUpdate{RedrivePolicy: &queue.RedrivePolicy{DeadLetterTargetQueue: "A", MaxReceiveCount: 1}}
+	if view := qm.UpdateQueueConfig(time.Now(), "B", redrive); view.Code != queue.INVALID_QUEUE_CONFIG {
+		t.Errorf("Expected INVALID_QUEUE_CONFIG for a redrive cycle, got %v", view.Code)
+	}
+	redrive.RedrivePolicy.DeadLetterTargetQueue = "Missing"
+	if view := qm.UpdateQueueConfig(time.Now(), "B", redrive); view.Code != queue.DEAD_LETTER_TARGET_NOT_FOUND {
+		t.Errorf("Expected DEAD_LETTER_TARGET_NOT_FOUND, got %v", view.Code)
+	}
+
+	// An update without a redrive policy keeps it, so C can not be deleted
+	qm.UpdateQueueConfig(time.Now(), "A", queue.ConfigUpdate{VisibilityTimeout: ptr(time.Second)})
+	if code := qm.DeleteQueue("C"); code != queue.QUEUE_IN_USE {
+		t.Errorf("Expected QUEUE_IN_USE while A dead letters to C, got %v", code)
+	}
+
+	// Removing the policy frees C to be deleted
+	qm.UpdateQueueConfig(time.Now(), "A", queue.ConfigUpdate{RedrivePolicy: &queue.RedrivePolicy{}})
+	if code := qm.DeleteQueue("C"); code != queue.OK {
+		t.Errorf("Expected C to be deleted once no queue dead letters to it, got %v", code)
+	}
+	if view := qm.GetQueueConfig(time.Now(), "C"); view.Code != queue.QUEUE_NOT_FOUND {
+		t.Errorf("Expected QUEUE_NOT_FOUND, got %v", view.Code)
+	}
+}
+
+func TestTick(t *testing.T) {
+	qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	qm.CreateQueue(queue.QueueConfig{Name: "DeadLetters", Type: queue.QueueTypeStandard})
+	qm.CreateQueue(queue.QueueConfig{
+		Name:              "Source",
+		Type:              queue.QueueTypeStandard,
+		VisibilityTimeout: 30 * time.Second,
+		RetentionPeriod:   time.Hour,
+		RedrivePolicy:     queue.RedrivePolicy{DeadLetterTargetQueue: "DeadLetters", MaxReceiveCount: 5},
+	})
+
+	qm.SendMessage(start, "Source", queue.Message{ID: "msg-1", Body: []byte("Received"), TimeStamp: start})
+	qm.SendMessage(start, "Source", queue.Message{ID: "msg-2", Body: []byte("Delayed"), TimeStamp: start, DelaySeconds: 60})
+	qm.PeekMessage(start, "Source")
+
+	// Ticking before anything is due changes nothing
+	qm.Tick(start.Add(10*time.Second), nil)
+	if view := qm.ViewAllMessages("Source"); len(view.Messages) != 0 || len(view.Delayed) != 1 {
+		t.Errorf("Expected no ready and 1 delayed message, got %d and %d", len(view.Messages), len(view.Delayed))
+	}
+
+	qm.Tick(start.Add(time.Minute), []string{"Source", "Deleted"})
+	view := qm.ViewAllMessages("Source")
+	if len(view.Messages) != 2 || len(view.Delayed) != 0 {
+		t.Errorf("Expected the in flight and the delayed message ready, got %d ready and %d delayed", len(view.Messages), len(view.Delayed))
+	}
+
+	// Without a single request to the source, its expired messages reach the redrive target
+	qm.Tick(start.Add(2*time.Hour), nil)
+	if view := qm.ViewAllMessages("Source"); len(view.Messages) != 0 {
+		t.Errorf("Expected the source to be empty, got %d messages", len(view.Messages))
+	}
+	if view := qm.ViewAllMessages("DeadLetters"); len(view.Messages) != 2 {
+		t.Errorf("Expected 2 expired messages in the target, got %d", len(view.Messages))
+	}
+}
+
+func TestConfigRequestsMoveDeadLetters(t *testing.T) {
+	qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	qm.CreateQueue(queue.QueueConfig{Name: "DeadLetters", Type: queue.QueueTypeStandard})
+	qm.CreateQueue(queue.QueueConfig{
+		Name:            "Source",
+		Type:            queue.QueueTypeStandard,
+		RetentionPeriod: time.Minute,
+		RedrivePolicy:   queue.RedrivePolicy{DeadLetterTargetQueue: "DeadLetters", MaxReceiveCount: 3},
+	})
+
+	// Reading or changing the config expires messages like any other request, they must reach the target
+	qm.SendMessage(start, "Source", queue.Message{ID: "msg-1"})
+	qm.GetQueueConfig(start.Add(2*time.Minute), "Source")
+	qm.SendMessage(start.Add(2*time.Minute), "Source", queue.Message{ID: "msg-2"})
+	qm.UpdateQueueConfig(start.Add(4*time.Minute), "Source", queue.ConfigUpdate{VisibilityTimeout: ptr(time.Second)})
+
+	var moved []string
+	for _, message := range qm.ViewAllMessages("DeadLetters").Messages {
+		moved = append(moved, message.ID)
+	}
+	if fmt.Sprint(moved) != "[msg-1 msg-2]" {
+		t.Errorf("Expected msg-1 and msg-2 dead lettered, got %v", moved)
+	}
+}
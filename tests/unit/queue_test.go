@@ -1,7 +1,10 @@
 package unit_test
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -37,10 +40,10 @@ func TestInsertQueue(t *testing.T) {
 
 	message := queue.Message{
 		ID:   "msg-1",
-		Body: "Hello, World!",
+		Body: []byte("Hello, World!"),
 	}
 
-	response := queueIO.InsertQueue(message)
+	response := queueIO.InsertQueue(time.Now(), message)
 
 	if response.Code != queue.OK {
 		t.Errorf("Expected OK, got %v", response.Code)
@@ -48,7 +51,7 @@ func TestInsertQueue(t *testing.T) {
 	if response.Message.ID != message.ID {
 		t.Errorf("Expected message ID %s, got %s", message.ID, response.Message.ID)
 	}
-	if response.Message.Body != message.Body {
+	if !bytes.Equal(response.Message.Body, message.Body) {
 		t.Errorf("Expected message body %s, got %s", message.Body, response.Message.Body)
 	}
 }
@@ -58,7 +61,7 @@ func TestPeekQueue(t *testing.T) {
 	defer queueIO.Close()
 
 	// Test peek on empty queue
-	response := queueIO.PeekQueue()
+	response := queueIO.PeekQueue(time.Now())
 	if response.Code != queue.EMPTY_QUEUE {
 		t.Errorf("Expected EMPTY_QUEUE, got %v", response.Code)
 	}
@@ -66,11 +69,11 @@ func TestPeekQueue(t *testing.T) {
 	// Insert a message and test peek
 	message := queue.Message{
 		ID:   "msg-2",
-		Body: "Test message",
+		Body: []byte("Test message"),
 	}
-	queueIO.InsertQueue(message)
+	queueIO.InsertQueue(time.Now(), message)
 
-	response = queueIO.PeekQueue()
+	response = queueIO.PeekQueue(time.Now())
 	if response.Code != queue.OK {
 		t.Errorf("Expected OK, got %v", response.Code)
 	}
@@ -78,10 +81,14 @@ func TestPeekQueue(t *testing.T) {
 		t.Errorf("Expected message ID %s, got %s", message.ID, response.Message.ID)
 	}
 
-	// Peek again to ensure message is still there
-	response = queueIO.PeekQueue()
-	if response.Code != queue.OK {
-		t.Errorf("Expected OK on second peek, got %v", response.Code)
+	if response.ReceiptHandle == "" {
+		t.Error("Expected a receipt handle")
+	}
+
+	// Peek again, the message is hidden until its visibility timeout passes
+	response = queueIO.PeekQueue(time.Now())
+	if response.Code != queue.EMPTY_QUEUE {
+		t.Errorf("Expected EMPTY_QUEUE on second peek, got %v", response.Code)
 	}
 }
 
@@ -89,26 +96,36 @@ func TestRemoveQueue(t *testing.T) {
 	queueIO := queue.MakeQueue("id", config)
 	defer queueIO.Close()
 
-	// Test delete on empty queue
-	response := queueIO.RemoveQueue()
-	if response.Code != queue.EMPTY_QUEUE {
-		t.Errorf("Expected EMPTY_QUEUE, got %v", response.Code)
+	// Test delete with an unknown receipt handle
+	response := queueIO.RemoveQueue(time.Now(), "unknown")
+	if response.Code != queue.INVALID_RECEIPT_HANDLE {
+		t.Errorf("Expected INVALID_RECEIPT_HANDLE, got %v", response.Code)
 	}
 
 	// Insert a message and test delete
 	message := queue.Message{
 		ID:   "msg-3",
-		Body: "To be deleted",
+		Body: []byte("To be deleted"),
 	}
-	queueIO.InsertQueue(message)
+	queueIO.InsertQueue(time.Now(), message)
 
-	response = queueIO.RemoveQueue()
+	response = queueIO.PeekQueue(time.Now())
+	response = queueIO.RemoveQueue(time.Now(), response.ReceiptHandle)
 	if response.Code != queue.OK {
 		t.Errorf("Expected OK, got %v", response.Code)
 	}
+	if response.Message.ID != message.ID {
+		t.Errorf("Expected message ID %s, got %s", message.ID, response.Message.ID)
+	}
+
+	// The receipt handle can only be used once
+	response = queueIO.RemoveQueue(time.Now(), response.ReceiptHandle)
+	if response.Code != queue.INVALID_RECEIPT_HANDLE {
+		t.Errorf("Expected INVALID_RECEIPT_HANDLE on second delete, got %v", response.Code)
+	}
 
 	// Verify message is deleted by peeking
-	peekResponse := queueIO.PeekQueue()
+	peekResponse := queueIO.PeekQueue(time.Now())
 	if peekResponse.Code != queue.EMPTY_QUEUE {
 		t.Errorf("Expected EMPTY_QUEUE after delete, got %v", peekResponse.Code)
 	}
@@ -119,7 +136,7 @@ func TestRequeueQueue(t *testing.T) {
 	defer queueIO.Close()
 
 	// Test requeue on empty dead letter queue
-	response := queueIO.Requeue()
+	response := queueIO.Requeue(time.Now())
 	if response.Code != queue.EMPTY_DEAD_LETTER_QUEUE {
 		t.Errorf("Expected EMPTY_DEAD_LETTER_QUEUE, got %v", response.Code)
 	}
@@ -131,26 +148,26 @@ func TestQueueFIFOBehavior(t *testing.T) {
 
 	// Insert multiple messages
 	messages := []queue.Message{
-		{ID: "msg-1", Body: "First"},
-		{ID: "msg-2", Body: "Second"},
-		{ID: "msg-3", Body: "Third"},
+		{ID: "msg-1", Body: []byte("First")},
+		{ID: "msg-2", Body: []byte("Second")},
+		{ID: "msg-3", Body: []byte("Third")},
 	}
 
 	for _, msg := range messages {
-		queueIO.InsertQueue(msg)
+		queueIO.InsertQueue(time.Now(), msg)
 	}
 
 	// Peek should return first message
-	response := queueIO.PeekQueue()
+	response := queueIO.PeekQueue(time.Now())
 	if response.Message.ID != "msg-1" {
 		t.Errorf("Expected first message, got %s", response.Message.ID)
 	}
 
 	// Delete should remove first message
-	queueIO.RemoveQueue()
+	queueIO.RemoveQueue(time.Now(), response.ReceiptHandle)
 
 	// Peek should now return second message
-	response = queueIO.PeekQueue()
+	response = queueIO.PeekQueue(time.Now())
 	if response.Message.ID != "msg-2" {
 		t.Errorf("Expected second message after delete, got %s", response.Message.ID)
 	}
@@ -168,9 +185,9 @@ func TestConcurrentOperations(t *testing.T) {
 		go func(id int) {
 			message := queue.Message{
 				ID:   fmt.Sprintf("msg-%d", id),
-				Body: fmt.Sprintf("Message %d", id),
+				Body: []byte(fmt.Sprintf("Message %d", id)),
 			}
-			response := queueIO.InsertQueue(message)
+			response := queueIO.InsertQueue(time.Now(), message)
 			if response.Code != queue.OK {
 				t.Errorf("Insert failed for message %d", id)
 			}
@@ -183,21 +200,15 @@ func TestConcurrentOperations(t *testing.T) {
 		<-done
 	}
 
-	// Verify we can peek and get a message
-	response := queueIO.PeekQueue()
-	if response.Code != queue.OK {
-		t.Errorf("Expected OK after concurrent inserts, got %v", response.Code)
-	}
-
 	// Count messages to verify all were inserted
 	messageCount := 0
 	for {
-		peekResp := queueIO.PeekQueue()
+		peekResp := queueIO.PeekQueue(time.Now())
 		if peekResp.Code == queue.EMPTY_QUEUE {
 			break
 		}
 		messageCount++
-		deleteResp := queueIO.RemoveQueue()
+		deleteResp := queueIO.RemoveQueue(time.Now(), peekResp.ReceiptHandle)
 		if deleteResp.Code != queue.OK {
 			t.Errorf("Failed to delete message %d", messageCount)
 		}
@@ -214,8 +225,8 @@ func TestConcurrentMixedOperations(t *testing.T) {
 
 	// Pre-populate with some messages
 	for i := 0; i < 5; i++ {
-		msg := queue.Message{ID: fmt.Sprintf("init-%d", i), Body: "initial"}
-		queueIO.InsertQueue(msg)
+		msg := queue.Message{ID: fmt.Sprintf("init-%d", i), Body: []byte("initial")}
+		queueIO.InsertQueue(time.Now(), msg)
 	}
 
 	var insertCount, deleteCount, peekCount int32
@@ -226,9 +237,9 @@ func TestConcurrentMixedOperations(t *testing.T) {
 		go func(id int) {
 			message := queue.Message{
 				ID:   fmt.Sprintf("concurrent-%d", id),
-				Body: fmt.Sprintf("Body %d", id),
+				Body: []byte(fmt.Sprintf("Body %d", id)),
 			}
-			response := queueIO.InsertQueue(message)
+			response := queueIO.InsertQueue(time.Now(), message)
 			if response.Code == queue.OK {
 				atomic.AddInt32(&insertCount, 1)
 			}
@@ -236,10 +247,11 @@ func TestConcurrentMixedOperations(t *testing.T) {
 		}(i)
 	}
 
-	// Concurrent deletes
+	// Concurrent receive and deletes
 	for i := 0; i < 10; i++ {
 		go func() {
-			response := queueIO.RemoveQueue()
+			response := queueIO.PeekQueue(time.Now())
+			response = queueIO.RemoveQueue(time.Now(), response.ReceiptHandle)
 			if response.Code == queue.OK {
 				atomic.AddInt32(&deleteCount, 1)
 			}
@@ -250,7 +262,7 @@ func TestConcurrentMixedOperations(t *testing.T) {
 	// Concurrent peeks
 	for i := 0; i < 10; i++ {
 		go func() {
-			response := queueIO.PeekQueue()
+			response := queueIO.PeekQueue(time.Now())
 			if response.Code == queue.OK {
 				atomic.AddInt32(&peekCount, 1)
 			}
@@ -264,8 +276,8 @@ func TestConcurrentMixedOperations(t *testing.T) {
 	}
 
 	// Verify queue is still functional
-	testMsg := queue.Message{ID: "final-test", Body: "test"}
-	response := queueIO.InsertQueue(testMsg)
+	testMsg := queue.Message{ID: "final-test", Body: []byte("test")}
+	response := queueIO.InsertQueue(time.Now(), testMsg)
 	if response.Code != queue.OK {
 		t.Error("Queue not functional after concurrent operations")
 	}
@@ -275,8 +287,8 @@ func TestCloseQueue(t *testing.T) {
 	queueIO := queue.MakeQueue("id", config)
 
 	// Insert a message
-	message := queue.Message{ID: "msg-1", Body: "Test"}
-	response := queueIO.InsertQueue(message)
+	message := queue.Message{ID: "msg-1", Body: []byte("Test")}
+	response := queueIO.InsertQueue(time.Now(), message)
 	if response.Code != queue.OK {
 		t.Errorf("Expected OK, got %v", response.Code)
 	}
@@ -289,47 +301,1090 @@ func TestCloseQueue(t *testing.T) {
 }
 
 func TestMaxReceive(t *testing.T) {
+	// A zero visibility timeout makes every received message visible again right away.
+	config := config
+	config.VisibilityTimeout = 0
 	queueIO := queue.MakeQueue("id", config)
 	defer queueIO.Close()
 
 	// Insert two messages
-	message := queue.Message{ID: "msg-1", Body: "Test"}
-	message2 := queue.Message{ID: "msg-2", Body: "Test 2"}
-	response := queueIO.InsertQueue(message)
+	message := queue.Message{ID: "msg-1", Body: []byte("Test")}
+	message2 := queue.Message{ID: "msg-2", Body: []byte("Test 2")}
+	response := queueIO.InsertQueue(time.Now(), message)
 	if response.Code != queue.OK {
 		t.Errorf("Expected OK, got %v", response.Code)
 	}
 
-	response = queueIO.InsertQueue(message2)
+	response = queueIO.InsertQueue(time.Now(), message2)
 	if response.Code != queue.OK {
 		t.Errorf("Expected OK, got %v", response.Code)
 	}
 
 	// Peek the message multiple times. Max receive count is 3, so it should be moved to dead letter queue after 3 peeks.
 	for i := 0; i < int(config.MaxReceiveCount); i++ {
-		response = queueIO.PeekQueue()
+		response = queueIO.PeekQueue(time.Now())
 		if response.Code != queue.OK {
 			t.Errorf("Expected OK, got %v", response.Code)
 		}
 	}
 
 	// Now the message-1 should be in the dead letter queue, peek should return message-2
-	response = queueIO.PeekQueue()
+	response = queueIO.PeekQueue(time.Now())
 	if response.Message.ID != "msg-2" {
 		t.Errorf("Expected message-2, got %s", response.Message.ID)
 	}
 
 	// Peek message-2 maxreceivcount - 1  more times.
 	for i := 0; i < int(config.MaxReceiveCount-1); i++ {
-		response = queueIO.PeekQueue()
+		response = queueIO.PeekQueue(time.Now())
 		if response.Code != queue.OK {
 			t.Errorf("Expected OK, got %v", response.Code)
 		}
 	}
 
 	// Now message-2 should be in the dead letter queue, peek should return empty
-	response = queueIO.PeekQueue()
+	response = queueIO.PeekQueue(time.Now())
 	if response.Code != queue.EMPTY_QUEUE {
 		t.Errorf("Expected EMPTY_QUEUE, got %v", response.Code)
 	}
 }
+
+func TestVisibilityTimeout(t *testing.T) {
+	now := time.Now()
+	config := config
+	config.Type = queue.QueueTypeStandard
+	config.VisibilityTimeout = 50 * time.Millisecond
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-1", Body: []byte("First")})
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-2", Body: []byte("Second")})
+
+	// Both messages can be in flight at the same time
+	first := queueIO.PeekQueue(now)
+	second := queueIO.PeekQueue(now)
+	if first.Message.ID != "msg-1" || second.Message.ID != "msg-2" {
+		t.Fatalf("Expected msg-1 and msg-2, got %s and %s", first.Message.ID, second.Message.ID)
+	}
+	if first.ReceiptHandle == second.ReceiptHandle {
+		t.Error("Expected distinct receipt handles")
+	}
+
+	response := queueIO.PeekQueue(now)
+	if response.Code != queue.EMPTY_QUEUE {
+		t.Errorf("Expected EMPTY_QUEUE while messages are in flight, got %v", response.Code)
+	}
+
+	snapshot := queueIO.SnapshotQueue()
+	if len(snapshot.InFlight) != 2 {
+		t.Errorf("Expected 2 in flight messages, got %d", len(snapshot.InFlight))
+	}
+
+	// Delete the second message, the first one is never deleted and reappears after the timeout
+	if response := queueIO.RemoveQueue(now, second.ReceiptHandle); response.Code != queue.OK {
+		t.Errorf("Expected OK, got %v", response.Code)
+	}
+	now = now.Add(2 * config.VisibilityTimeout)
+
+	response = queueIO.PeekQueue(now)
+	if response.Message.ID != "msg-1" {
+		t.Errorf("Expected msg-1 to reappear, got %s", response.Message.ID)
+	}
+	if response.ReceiptHandle == first.ReceiptHandle {
+		t.Error("Expected a new receipt handle for the second receive")
+	}
+
+	// The handle from the first receive expired with its visibility timeout
+	if response := queueIO.RemoveQueue(now, first.ReceiptHandle); response.Code != queue.INVALID_RECEIPT_HANDLE {
+		t.Errorf("Expected INVALID_RECEIPT_HANDLE, got %v", response.Code)
+	}
+	if response := queueIO.RemoveQueue(now, response.ReceiptHandle); response.Code != queue.OK {
+		t.Errorf("Expected OK, got %v", response.Code)
+	}
+}
+
+func TestRetentionPeriod(t *testing.T) {
+	now := time.Now()
+	config := config
+	config.RetentionPeriod = 100 * time.Millisecond
+	config.VisibilityTimeout = 0
+	config.MaxReceiveCount = 1
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	// Receive msg-1 twice so it is moved to the dead letter queue
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-1", Body: []byte("Dead letter")})
+	queueIO.PeekQueue(now)
+	if response := queueIO.PeekQueue(now); response.Code != queue.EMPTY_QUEUE {
+		t.Fatalf("Expected EMPTY_QUEUE, got %v", response.Code)
+	}
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-2", Body: []byte("Expires")})
+
+	// A message stamped before the retention period expires right away
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-3", Body: []byte("Old"), TimeStamp: now.Add(-time.Hour)})
+	snapshot := queueIO.SnapshotQueue()
+	if len(snapshot.Messages) != 2 || snapshot.ExpiredMessages != 0 {
+		t.Fatalf("Expected 2 messages and none expired, got %d and %d", len(snapshot.Messages), snapshot.ExpiredMessages)
+	}
+	if response := queueIO.PeekQueue(now); response.Message.ID != "msg-2" {
+		t.Errorf("Expected msg-2, got %s", response.Message.ID)
+	}
+	if snapshot := queueIO.SnapshotQueue(); snapshot.ExpiredMessages != 1 {
+		t.Errorf("Expected 1 expired message, got %d", snapshot.ExpiredMessages)
+	}
+
+	now = now.Add(2 * config.RetentionPeriod)
+
+	if response := queueIO.PeekQueue(now); response.Code != queue.EMPTY_QUEUE {
+		t.Errorf("Expected EMPTY_QUEUE after retention period, got %v", response.Code)
+	}
+	snapshot = queueIO.SnapshotQueue()
+	if snapshot.ExpiredMessages != 2 {
+		t.Errorf("Expected 2 expired messages, got %d", snapshot.ExpiredMessages)
+	}
+	if snapshot.ExpiredDeadLetters != 1 || len(snapshot.DeadLetterQueue) != 0 {
+		t.Errorf("Expected the dead letter to expire, got %d expired and %d left", snapshot.ExpiredDeadLetters, len(snapshot.DeadLetterQueue))
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	response := queueIO.InsertQueue(time.Now(), queue.Message{ID: "msg-1", Body: []byte(strings.Repeat("a", int(config.MaxMessageSize)))})
+	if response.Code != queue.OK {
+		t.Errorf("Expected OK for a message at the size limit, got %v", response.Code)
+	}
+
+	response = queueIO.InsertQueue(time.Now(), queue.Message{ID: "msg-2", Body: []byte(strings.Repeat("a", int(config.MaxMessageSize)+1))})
+	if response.Code != queue.MESSAGE_TOO_LARGE {
+		t.Errorf("Expected MESSAGE_TOO_LARGE, got %v", response.Code)
+	}
+
+	// Without a configured size the cluster wide limit applies
+	unlimited := config
+	unlimited.MaxMessageSize = 0
+	unlimitedIO := queue.MakeQueue("unlimited", unlimited)
+	defer unlimitedIO.Close()
+
+	response = unlimitedIO.InsertQueue(time.Now(), queue.Message{ID: "msg-3", Body: []byte(strings.Repeat("a", int(queue.MaxMessageSizeLimit)+1))})
+	if response.Code != queue.MESSAGE_TOO_LARGE {
+		t.Errorf("Expected MESSAGE_TOO_LARGE above the cluster limit, got %v", response.Code)
+	}
+
+	if snapshot := queueIO.SnapshotQueue(); len(snapshot.Messages) != 1 {
+		t.Errorf("Expected only the message within the limit to be queued, got %d", len(snapshot.Messages))
+	}
+}
+
+func TestStandardQueueParallelReceive(t *testing.T) {
+	config := config
+	config.Type = queue.QueueTypeStandard
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	for i := 0; i < 3; i++ {
+		queueIO.InsertQueue(time.Now(), queue.Message{ID: fmt.Sprintf("msg-%d", i), Body: []byte("Standard")})
+	}
+
+	// Every message can be in flight at once, before any of them is deleted
+	received := make(map[string]string)
+	for i := 0; i < 3; i++ {
+		response := queueIO.PeekQueue(time.Now())
+		if response.Code != queue.OK {
+			t.Fatalf("Expected OK on receive %d, got %v", i, response.Code)
+		}
+		received[response.Message.ID] = response.ReceiptHandle
+	}
+	if len(received) != 3 {
+		t.Errorf("Expected 3 distinct messages in flight, got %d", len(received))
+	}
+
+	for id, handle := range received {
+		if response := queueIO.RemoveQueue(time.Now(), handle); response.Code != queue.OK {
+			t.Errorf("Expected OK deleting %s, got %v", id, response.Code)
+		}
+	}
+}
+
+func TestFIFOQueueStrictOrdering(t *testing.T) {
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	for i := 0; i < 3; i++ {
+		queueIO.InsertQueue(time.Now(), queue.Message{ID: fmt.Sprintf("msg-%d", i), Body: []byte("FIFO")})
+	}
+
+	for i := 0; i < 3; i++ {
+		response := queueIO.PeekQueue(time.Now())
+		expectedID := fmt.Sprintf("msg-%d", i)
+		if response.Message.ID != expectedID {
+			t.Fatalf("Expected %s, got %s", expectedID, response.Message.ID)
+		}
+
+		// Nothing else is handed out while the head is in flight
+		if blocked := queueIO.PeekQueue(time.Now()); blocked.Code != queue.EMPTY_QUEUE {
+			t.Errorf("Expected EMPTY_QUEUE while %s is in flight, got %v with %s", expectedID, blocked.Code, blocked.Message.ID)
+		}
+
+		if deleted := queueIO.RemoveQueue(time.Now(), response.ReceiptHandle); deleted.Code != queue.OK {
+			t.Errorf("Expected OK deleting %s, got %v", expectedID, deleted.Code)
+		}
+	}
+}
+
+func TestInvalidQueueType(t *testing.T) {
+	config := config
+	config.Type = "unknown"
+	if code := config.Validate(); code != queue.INVALID_QUEUE_CONFIG {
+		t.Errorf("Expected INVALID_QUEUE_CONFIG, got %v", code)
+	}
+}
+
+func TestFIFOMessageGroups(t *testing.T) {
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	queueIO.InsertQueue(time.Now(), queue.Message{ID: "a-1", Body: []byte("First of A"), MessageGroupID: "A"})
+	queueIO.InsertQueue(time.Now(), queue.Message{ID: "a-2", Body: []byte("Second of A"), MessageGroupID: "A"})
+	queueIO.InsertQueue(time.Now(), queue.Message{ID: "b-1", Body: []byte("First of B"), MessageGroupID: "B"})
+
+	first := queueIO.PeekQueue(time.Now())
+	if first.Message.ID != "a-1" {
+		t.Fatalf("Expected a-1, got %s", first.Message.ID)
+	}
+
+	// Group A is blocked behind a-1, group B is still delivered
+	response := queueIO.PeekQueue(time.Now())
+	if response.Message.ID != "b-1" {
+		t.Errorf("Expected b-1 while a-1 is in flight, got %s", response.Message.ID)
+	}
+	if response := queueIO.PeekQueue(time.Now()); response.Code != queue.EMPTY_QUEUE {
+		t.Errorf("Expected EMPTY_QUEUE while both groups are in flight, got %v with %s", response.Code, response.Message.ID)
+	}
+
+	queueIO.RemoveQueue(time.Now(), first.ReceiptHandle)
+	if response := queueIO.PeekQueue(time.Now()); response.Message.ID != "a-2" {
+		t.Errorf("Expected a-2 after a-1 is deleted, got %s", response.Message.ID)
+	}
+}
+
+func TestFIFOReceiveBehindBlockedGroup(t *testing.T) {
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	now := time.Now()
+	for _, message := range []queue.Message{
+		{ID: "a-1", MessageGroupID: "a"},
+		{ID: "a-2", MessageGroupID: "a"},
+		{ID: "b-1", MessageGroupID: "b"},
+		{ID: "a-3", MessageGroupID: "a"},
+	} {
+		queueIO.InsertQueue(now, message)
+	}
+
+	// Group a is blocked by a-1, so b-1 is received from behind a-2
+	first := queueIO.PeekQueue(now)
+	second := queueIO.PeekQueue(now)
+	if first.Message.ID != "a-1" || second.Message.ID != "b-1" {
+		t.Fatalf("Expected a-1 then b-1, got %s and %s", first.Message.ID, second.Message.ID)
+	}
+
+	// b-1 becomes visible again and goes back to the front, its old place is not delivered twice
+	queueIO.ChangeVisibility(now, second.ReceiptHandle, 0)
+	queueIO.RemoveQueue(now, first.ReceiptHandle)
+
+	var received []string
+	for {
+		response := queueIO.PeekQueue(now)
+		if response.Code != queue.OK {
+			break
+		}
+		received = append(received, response.Message.ID)
+		queueIO.RemoveQueue(now, response.ReceiptHandle)
+	}
+	if fmt.Sprint(received) != "[b-1 a-2 a-3]" {
+		t.Errorf("Expected [b-1 a-2 a-3], got %v", received)
+	}
+}
+
+func TestDeduplicationID(t *testing.T) {
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	first := queueIO.InsertQueue(time.Now(), queue.Message{ID: "msg-1", Body: []byte("Order"), DeduplicationID: "order-1"})
+	retry := queueIO.InsertQueue(time.Now(), queue.Message{ID: "msg-2", Body: []byte("Order"), DeduplicationID: "order-1"})
+	if retry.Code != queue.OK {
+		t.Errorf("Expected OK for a duplicate send, got %v", retry.Code)
+	}
+	if retry.Message.ID != first.Message.ID {
+		t.Errorf("Expected the original message %s, got %s", first.Message.ID, retry.Message.ID)
+	}
+
+	// Messages without a deduplication ID are not deduplicated unless content based deduplication is on
+	queueIO.InsertQueue(time.Now(), queue.Message{ID: "msg-3", Body: []byte("Order")})
+	queueIO.InsertQueue(time.Now(), queue.Message{ID: "msg-4", Body: []byte("Order")})
+
+	if snapshot := queueIO.SnapshotQueue(); len(snapshot.Messages) != 3 {
+		t.Errorf("Expected 3 messages, got %d", len(snapshot.Messages))
+	}
+}
+
+func TestShortenedDeduplicationWindow(t *testing.T) {
+	long := config
+	long.DeduplicationWindow = time.Hour
+	queueIO := queue.MakeQueue("id", long)
+	defer queueIO.Close()
+
+	now := time.Now()
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-1", DeduplicationID: "order-1"})
+	queueIO.UpdateConfig(now, queue.ConfigUpdate{DeduplicationWindow: ptr(time.Minute)})
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-2", DeduplicationID: "order-2"})
+
+	// order-2 expires before order-1 although it was sent after it
+	queueIO.Tick(now.Add(2 * time.Minute))
+	deduplication := queueIO.SnapshotQueue().Deduplication
+	if _, exists := deduplication["order-2"]; exists || len(deduplication) != 1 {
+		t.Errorf("Expected only order-1 remembered, got %v", deduplication)
+	}
+}
+
+func TestContentBasedDeduplication(t *testing.T) {
+	now := time.Now()
+	config := config
+	config.ContentBasedDeduplication = true
+	config.DeduplicationWindow = 50 * time.Millisecond
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-1", Body: []byte("Same body")})
+	if response := queueIO.InsertQueue(now, queue.Message{ID: "msg-2", Body: []byte("Same body")}); response.Message.ID != "msg-1" {
+		t.Errorf("Expected the original msg-1, got %s", response.Message.ID)
+	}
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-3", Body: []byte("Other body")})
+
+	// Once the window passes the same body is enqueued again
+	now = now.Add(2 * config.DeduplicationWindow)
+	if response := queueIO.InsertQueue(now, queue.Message{ID: "msg-4", Body: []byte("Same body")}); response.Message.ID != "msg-4" {
+		t.Errorf("Expected msg-4 after the deduplication window, got %s", response.Message.ID)
+	}
+
+	if snapshot := queueIO.SnapshotQueue(); len(snapshot.Messages) != 3 {
+		t.Errorf("Expected 3 messages, got %d", len(snapshot.Messages))
+	}
+}
+
+func TestDelayedMessage(t *testing.T) {
+	now := time.Now()
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-1", Body: []byte("Delayed"), DelaySeconds: 1})
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-2", Body: []byte("Ready")})
+
+	snapshot := queueIO.SnapshotQueue()
+	if len(snapshot.Delayed) != 1 || len(snapshot.Messages) != 1 {
+		t.Fatalf("Expected 1 delayed and 1 ready message, got %d and %d", len(snapshot.Delayed), len(snapshot.Messages))
+	}
+
+	response := queueIO.PeekQueue(now)
+	if response.Message.ID != "msg-2" {
+		t.Errorf("Expected msg-2 while msg-1 is delayed, got %s", response.Message.ID)
+	}
+	queueIO.RemoveQueue(now, response.ReceiptHandle)
+	if response := queueIO.PeekQueue(now); response.Code != queue.EMPTY_QUEUE {
+		t.Errorf("Expected EMPTY_QUEUE before the delay passes, got %v", response.Code)
+	}
+
+	now = now.Add(1100 * time.Millisecond)
+	if response := queueIO.PeekQueue(now); response.Message.ID != "msg-1" {
+		t.Errorf("Expected msg-1 after the delay, got %s", response.Message.ID)
+	}
+
+	if response := queueIO.InsertQueue(now, queue.Message{ID: "msg-3", DelaySeconds: queue.MaxDelaySeconds + 1}); response.Code != queue.INVALID_MESSAGE {
+		t.Errorf("Expected INVALID_MESSAGE for a delay above the maximum, got %v", response.Code)
+	}
+}
+
+func TestScheduledMessage(t *testing.T) {
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	now := time.Now()
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-1", Body: []byte("Soon"), DeliverAt: now.Add(100 * time.Millisecond)})
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-2", Body: []byte("Later"), DeliverAt: now.Add(30 * time.Minute)})
+
+	if response := queueIO.InsertQueue(now, queue.Message{ID: "msg-3", DeliverAt: now.Add(2 * config.RetentionPeriod)}); response.Code != queue.INVALID_MESSAGE {
+		t.Errorf("Expected INVALID_MESSAGE for a DeliverAt past the retention period, got %v", response.Code)
+	}
+
+	snapshot := queueIO.SnapshotQueue()
+	if len(snapshot.Scheduled) != 2 || snapshot.Scheduled[0].Message.ID != "msg-1" {
+		t.Fatalf("Expected msg-1 and msg-2 scheduled in order, got %v", snapshot.Scheduled)
+	}
+	if response := queueIO.PeekQueue(now); response.Code != queue.EMPTY_QUEUE {
+		t.Errorf("Expected EMPTY_QUEUE before DeliverAt, got %v", response.Code)
+	}
+
+	if response := queueIO.CancelScheduled(now, "msg-2"); response.Code != queue.OK {
+		t.Errorf("Expected OK cancelling msg-2, got %v", response.Code)
+	}
+	if response := queueIO.CancelScheduled(now, "msg-2"); response.Code != queue.MESSAGE_NOT_FOUND {
+		t.Errorf("Expected MESSAGE_NOT_FOUND cancelling msg-2 twice, got %v", response.Code)
+	}
+
+	now = now.Add(150 * time.Millisecond)
+	if response := queueIO.PeekQueue(now); response.Message.ID != "msg-1" {
+		t.Errorf("Expected msg-1 after its DeliverAt, got %s", response.Message.ID)
+	}
+	if snapshot := queueIO.SnapshotQueue(); len(snapshot.Scheduled) != 0 {
+		t.Errorf("Expected no scheduled messages left, got %d", len(snapshot.Scheduled))
+	}
+}
+
+func TestMessageAttributes(t *testing.T) {
+	config := config
+	config.VisibilityTimeout = 0
+	config.MaxReceiveCount = 1
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	attributes := map[string]queue.MessageAttribute{
+		"customer": {DataType: queue.AttributeTypeString, StringValue: "acme"},
+		"amount":   {DataType: queue.AttributeTypeNumber, StringValue: "42.5"},
+		"trace":    {DataType: queue.AttributeTypeBinary, BinaryValue: []byte{0x00, 0xff}},
+	}
+	queueIO.InsertQueue(time.Now(), queue.Message{ID: "msg-1", Body: []byte("Order"), Attributes: attributes})
+
+	response := queueIO.PeekQueue(time.Now())
+	if response.Message.Attributes["customer"].StringValue != "acme" {
+		t.Errorf("Expected the customer attribute on receive, got %v", response.Message.Attributes)
+	}
+
+	// Attributes stay with the message when it is moved to the dead letter queue
+	queueIO.PeekQueue(time.Now())
+	snapshot := queueIO.SnapshotQueue()
+	if len(snapshot.DeadLetterQueue) != 1 || len(snapshot.DeadLetterQueue[0].Attributes) != 3 {
+		t.Fatalf("Expected the dead letter to keep 3 attributes, got %v", snapshot.DeadLetterQueue)
+	}
+	if trace := snapshot.DeadLetterQueue[0].Attributes["trace"].BinaryValue; len(trace) != 2 || trace[1] != 0xff {
+		t.Errorf("Expected the binary trace attribute, got %v", trace)
+	}
+
+	invalid := []map[string]queue.MessageAttribute{
+		{"amount": {DataType: queue.AttributeTypeNumber, StringValue: "forty"}},
+		{"bad name": {DataType: queue.AttributeTypeString, StringValue: "value"}},
+		{"empty": {DataType: queue.AttributeTypeBinary}},
+		{"unknown": {DataType: "Date", StringValue: "today"}},
+	}
+	tooMany := make(map[string]queue.MessageAttribute)
+	for i := 0; i <= queue.MaxMessageAttributes; i++ {
+		tooMany[fmt.Sprintf("attr-%d", i)] = queue.MessageAttribute{DataType: queue.AttributeTypeString, StringValue: "value"}
+	}
+	invalid = append(invalid, tooMany)
+
+	for i, attributes := range invalid {
+		if response := queueIO.InsertQueue(time.Now(), queue.Message{ID: "invalid", Body: []byte("Invalid"), Attributes: attributes}); response.Code != queue.INVALID_MESSAGE {
+			t.Errorf("Expected INVALID_MESSAGE for attributes %d, got %v", i, response.Code)
+		}
+	}
+
+	// Attributes count against the message size limit
+	large := map[string]queue.MessageAttribute{
+		"padding": {DataType: queue.AttributeTypeString, StringValue: strings.Repeat("a", int(config.MaxMessageSize))},
+	}
+	if response := queueIO.InsertQueue(time.Now(), queue.Message{ID: "large", Body: []byte("Small"), Attributes: large}); response.Code != queue.MESSAGE_TOO_LARGE {
+		t.Errorf("Expected MESSAGE_TOO_LARGE, got %v", response.Code)
+	}
+}
+
+func TestBinaryMessageBody(t *testing.T) {
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	// Not valid UTF-8, so it would be mangled if it went through JSON as a plain string
+	body := []byte{0x08, 0x96, 0x01, 0xff, 0xfe, 0x00}
+	message := queue.Message{ID: "msg-1", Body: body, ContentType: "application/x-protobuf"}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+	var decoded queue.Message
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal message: %v", err)
+	}
+	if !bytes.Equal(decoded.Body, body) || decoded.ContentType != message.ContentType {
+		t.Errorf("Expected body %v with %s, got %v with %s", body, message.ContentType, decoded.Body, decoded.ContentType)
+	}
+
+	// Text bodies stay readable in JSON
+	data, _ = json.Marshal(queue.Message{ID: "msg-2", Body: []byte("Hello, World!")})
+	if !strings.Contains(string(data), `"Body":"Hello, World!"`) {
+		t.Errorf("Expected a plain text body, got %s", data)
+	}
+
+	queueIO.InsertQueue(time.Now(), decoded)
+	if response := queueIO.PeekQueue(time.Now()); !bytes.Equal(response.Message.Body, body) {
+		t.Errorf("Expected body %v on receive, got %v", body, response.Message.Body)
+	}
+
+	if err := json.Unmarshal([]byte(`{"Body":"abc","BodyEncoding":"rot13"}`), &decoded); err == nil {
+		t.Error("Expected an error for an unknown body encoding")
+	}
+}
+
+func TestBatchOperations(t *testing.T) {
+	config := config
+	config.Type = queue.QueueTypeStandard
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	response := queueIO.InsertQueueBatch(time.Now(), []queue.Message{
+		{ID: "msg-1", Body: []byte("First")},
+		{ID: "msg-2", Body: []byte(strings.Repeat("a", int(config.MaxMessageSize)+1))},
+		{ID: "msg-3", Body: []byte("Third")},
+	})
+	if response.Code != queue.OK || len(response.Batch) != 3 {
+		t.Fatalf("Expected OK with 3 results, got %v with %d", response.Code, len(response.Batch))
+	}
+	// A failed entry does not fail the rest of the batch
+	expected := []queue.Code{queue.OK, queue.MESSAGE_TOO_LARGE, queue.OK}
+	for i, code := range expected {
+		if response.Batch[i].Code != code {
+			t.Errorf("Expected %v for entry %d, got %v", code, i, response.Batch[i].Code)
+		}
+	}
+
+	response = queueIO.PeekQueueBatch(time.Now(), queue.MaxBatchSize)
+	if response.Code != queue.OK || len(response.Batch) != 2 {
+		t.Fatalf("Expected OK with 2 messages, got %v with %d", response.Code, len(response.Batch))
+	}
+	handles := []string{response.Batch[0].ReceiptHandle, "unknown", response.Batch[1].ReceiptHandle}
+
+	if response := queueIO.PeekQueueBatch(time.Now(), 1); response.Code != queue.EMPTY_QUEUE {
+		t.Errorf("Expected EMPTY_QUEUE with every message in flight, got %v", response.Code)
+	}
+
+	response = queueIO.RemoveQueueBatch(time.Now(), handles)
+	expected = []queue.Code{queue.OK, queue.INVALID_RECEIPT_HANDLE, queue.OK}
+	for i, code := range expected {
+		if response.Batch[i].Code != code {
+			t.Errorf("Expected %v for handle %d, got %v", code, i, response.Batch[i].Code)
+		}
+	}
+
+	if snapshot := queueIO.SnapshotQueue(); len(snapshot.Messages) != 0 || len(snapshot.InFlight) != 0 {
+		t.Errorf("Expected an empty queue, got %d waiting and %d in flight", len(snapshot.Messages), len(snapshot.InFlight))
+	}
+
+	tooMany := make([]queue.Message, queue.MaxBatchSize+1)
+	if response := queueIO.InsertQueueBatch(time.Now(), tooMany); response.Code != queue.INVALID_BATCH_SIZE {
+		t.Errorf("Expected INVALID_BATCH_SIZE, got %v", response.Code)
+	}
+	if response := queueIO.PeekQueueBatch(time.Now(), 0); response.Code != queue.INVALID_BATCH_SIZE {
+		t.Errorf("Expected INVALID_BATCH_SIZE, got %v", response.Code)
+	}
+	if response := queueIO.RemoveQueueBatch(time.Now(), nil); response.Code != queue.INVALID_BATCH_SIZE {
+		t.Errorf("Expected INVALID_BATCH_SIZE, got %v", response.Code)
+	}
+}
+
+func TestWaitForMessage(t *testing.T) {
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	wake := queueIO.WaitForMessage(time.Now().Add(time.Second))
+	select {
+	case <-wake:
+		t.Fatal("Expected the waiter to block on an empty queue")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// Delayed messages do not wake waiters until they are released
+	queueIO.InsertQueue(time.Now(), queue.Message{ID: "msg-1", Body: []byte("Delayed"), DelaySeconds: 60})
+	select {
+	case <-wake:
+		t.Fatal("Expected the waiter to keep blocking for a delayed message")
+	default:
+	}
+
+	go queueIO.InsertQueue(time.Now(), queue.Message{ID: "msg-2", Body: []byte("Ready")})
+	select {
+	case <-wake:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the waiter to be woken by a send")
+	}
+
+	if response := queueIO.PeekQueue(time.Now()); response.Message.ID != "msg-2" {
+		t.Errorf("Expected msg-2 after waking, got %s", response.Message.ID)
+	}
+}
+
+func TestFIFOWaitersWokenWhenGroupUnblocks(t *testing.T) {
+	config := config
+	config.VisibilityTimeout = 2 * time.Hour
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	now := time.Now()
+	woken := func(wake <-chan struct{}) bool {
+		select {
+		case <-wake:
+			return true
+		default:
+			return false
+		}
+	}
+	queueIO.InsertQueue(now, queue.Message{ID: "a-1", MessageGroupID: "a"})
+	queueIO.InsertQueue(now, queue.Message{ID: "a-2", MessageGroupID: "a"})
+	queueIO.InsertQueue(now.Add(30*time.Minute), queue.Message{ID: "a-3", MessageGroupID: "a"})
+
+	// Rejecting a-1 unblocks a-2
+	first := queueIO.PeekQueue(now.Add(30 * time.Minute))
+	wake := queueIO.WaitForMessage(now.Add(3 * time.Hour))
+	queueIO.RejectMessage(now.Add(30*time.Minute), first.ReceiptHandle, "poison")
+	if !woken(wake) {
+		t.Error("Expected the waiter to be woken when a rejected message unblocks its group")
+	}
+
+	// a-2 expiring while in flight unblocks a-3
+	if response := queueIO.PeekQueue(now.Add(30 * time.Minute)); response.Message.ID != "a-2" {
+		t.Fatalf("Expected a-2, got %s", response.Message.ID)
+	}
+	wake = queueIO.WaitForMessage(now.Add(3 * time.Hour))
+	queueIO.Tick(now.Add(config.RetentionPeriod + time.Minute))
+	if !woken(wake) {
+		t.Error("Expected the waiter to be woken when an expired message unblocks its group")
+	}
+}
+
+func TestPriorityQueue(t *testing.T) {
+	now := time.Now()
+	config := config
+	config.Type = queue.QueueTypePriority
+	config.VisibilityTimeout = 50 * time.Millisecond
+	config.MaxReceiveCount = 1
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	queueIO.InsertQueue(now, queue.Message{ID: "low", Body: []byte("Low"), Priority: 1})
+	queueIO.InsertQueue(now, queue.Message{ID: "high-1", Body: []byte("First high"), Priority: 5})
+	queueIO.InsertQueue(now, queue.Message{ID: "high-2", Body: []byte("Second high"), Priority: 5})
+	queueIO.InsertQueue(now, queue.Message{ID: "none", Body: []byte("No priority")})
+
+	snapshot := queueIO.SnapshotQueue()
+	var ids []string
+	for _, message := range snapshot.Messages {
+		ids = append(ids, message.ID)
+	}
+	if fmt.Sprint(ids) != "[high-1 high-2 low none]" {
+		t.Errorf("Expected snapshot in delivery order, got %v", ids)
+	}
+
+	// Restoring the snapshot keeps the priority order
+	restored := queue.RestoreQueue(snapshot)
+	defer restored.Close()
+	for _, expectedID := range []string{"high-1", "high-2", "low", "none"} {
+		if response := restored.PeekQueue(now); response.Message.ID != expectedID {
+			t.Errorf("Expected %s from the restored queue, got %s", expectedID, response.Message.ID)
+		}
+	}
+
+	first := queueIO.PeekQueue(now)
+	if first.Message.ID != "high-1" {
+		t.Fatalf("Expected high-1, got %s", first.Message.ID)
+	}
+
+	// A higher priority message sent later is still delivered first
+	queueIO.InsertQueue(now, queue.Message{ID: "urgent", Body: []byte("Urgent"), Priority: 10})
+	if response := queueIO.PeekQueue(now); response.Message.ID != "urgent" {
+		t.Errorf("Expected urgent, got %s", response.Message.ID)
+	}
+
+	// urgent and high-1 come back ahead of high-2 after their visibility timeout, and move to the
+	// dead letter queue in priority order on the next receive since MaxReceiveCount is 1
+	now = now.Add(2 * config.VisibilityTimeout)
+	if response := queueIO.PeekQueue(now); response.Message.ID != "high-2" {
+		t.Errorf("Expected high-2 after the released messages are dead lettered, got %s", response.Message.ID)
+	}
+	snapshot = queueIO.SnapshotQueue()
+	if len(snapshot.DeadLetterQueue) != 2 || snapshot.DeadLetterQueue[0].ID != "urgent" || snapshot.DeadLetterQueue[1].ID != "high-1" {
+		t.Errorf("Expected urgent and high-1 in the dead letter queue, got %d messages", len(snapshot.DeadLetterQueue))
+	}
+}
+
+func TestReceiveCountSurvivesRestore(t *testing.T) {
+	now := time.Now()
+	config := config
+	config.Type = queue.QueueTypeStandard
+	config.VisibilityTimeout = 50 * time.Millisecond
+	config.MaxReceiveCount = 2
+	queueIO := queue.MakeQueue("id", config)
+
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-1", Body: []byte("First")})
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-2", Body: []byte("Second")})
+
+	// Each in flight message carries its own count
+	first := queueIO.PeekQueue(now)
+	second := queueIO.PeekQueue(now)
+	if first.Message.ReceiveCount != 1 || second.Message.ReceiveCount != 1 {
+		t.Fatalf("Expected both messages received once, got %d and %d", first.Message.ReceiveCount, second.Message.ReceiveCount)
+	}
+	if first.Message.FirstReceiveTimestamp.IsZero() || !first.Message.FirstReceiveTimestamp.Equal(first.Message.LastReceiveTimestamp) {
+		t.Errorf("Expected the first receive to set both timestamps, got %v and %v", first.Message.FirstReceiveTimestamp, first.Message.LastReceiveTimestamp)
+	}
+	queueIO.RemoveQueue(now, second.ReceiptHandle)
+	now = now.Add(2 * config.VisibilityTimeout)
+
+	// Restore from a serialized snapshot, as a new leader does
+	data, err := json.Marshal(queueIO.SnapshotQueue())
+	if err != nil {
+		t.Fatalf("Failed to marshal snapshot: %v", err)
+	}
+	queueIO.Close()
+	var snapshot queue.Queue
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatalf("Failed to unmarshal snapshot: %v", err)
+	}
+	restored := queue.RestoreQueue(snapshot)
+	defer restored.Close()
+
+	response := restored.PeekQueue(now)
+	if response.Message.ID != "msg-1" || response.Message.ReceiveCount != 2 {
+		t.Fatalf("Expected msg-1 received twice, got %s received %d times", response.Message.ID, response.Message.ReceiveCount)
+	}
+	if !response.Message.FirstReceiveTimestamp.Equal(first.Message.FirstReceiveTimestamp) {
+		t.Errorf("Expected the first receive timestamp to be kept, got %v", response.Message.FirstReceiveTimestamp)
+	}
+	if !response.Message.LastReceiveTimestamp.After(first.Message.LastReceiveTimestamp) {
+		t.Errorf("Expected a later last receive timestamp, got %v", response.Message.LastReceiveTimestamp)
+	}
+	now = now.Add(2 * config.VisibilityTimeout)
+
+	// The third receive exceeds MaxReceiveCount and moves the message to the dead letter queue
+	if response := restored.PeekQueue(now); response.Code != queue.EMPTY_QUEUE {
+		t.Errorf("Expected EMPTY_QUEUE, got %v with %s", response.Code, response.Message.ID)
+	}
+	if deadLetters := restored.SnapshotQueue().DeadLetterQueue; len(deadLetters) != 1 || deadLetters[0].ReceiveCount != 2 {
+		t.Errorf("Expected msg-1 dead lettered after 2 receives, got %v", deadLetters)
+	}
+}
+
+func TestChangeVisibility(t *testing.T) {
+	now := time.Now()
+	config := config
+	config.Type = queue.QueueTypeStandard
+	config.VisibilityTimeout = 50 * time.Millisecond
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-1", Body: []byte("Long job")})
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-2", Body: []byte("Failed job")})
+	first := queueIO.PeekQueue(now)
+	second := queueIO.PeekQueue(now)
+
+	// Extending the visibility keeps the message hidden past its original timeout
+	if response := queueIO.ChangeVisibility(now, first.ReceiptHandle, time.Minute); response.Code != queue.OK || response.ReceiptHandle != first.ReceiptHandle {
+		t.Fatalf("Expected OK with the same receipt handle, got %v", response.Code)
+	}
+	now = now.Add(2 * config.VisibilityTimeout)
+	response := queueIO.PeekQueue(now)
+	if response.Message.ID != "msg-2" {
+		t.Fatalf("Expected only msg-2 to reappear, got %s", response.Message.ID)
+	}
+
+	// A zero timeout releases the message right away and invalidates its handle
+	if response := queueIO.ChangeVisibility(now, response.ReceiptHandle, 0); response.Code != queue.OK {
+		t.Errorf("Expected OK, got %v", response.Code)
+	}
+	if response := queueIO.ChangeVisibility(now, second.ReceiptHandle, time.Minute); response.Code != queue.INVALID_RECEIPT_HANDLE {
+		t.Errorf("Expected INVALID_RECEIPT_HANDLE for an expired handle, got %v", response.Code)
+	}
+	if response := queueIO.PeekQueue(now); response.Message.ID != "msg-2" || response.Message.ReceiveCount != 3 {
+		t.Errorf("Expected msg-2 received a third time, got %s received %d times", response.Message.ID, response.Message.ReceiveCount)
+	}
+
+	if response := queueIO.ChangeVisibility(now, first.ReceiptHandle, queue.MaxVisibilityTimeout+time.Second); response.Code != queue.INVALID_VISIBILITY_TIMEOUT {
+		t.Errorf("Expected INVALID_VISIBILITY_TIMEOUT, got %v", response.Code)
+	}
+	if response := queueIO.RemoveQueue(now, first.ReceiptHandle); response.Code != queue.OK {
+		t.Errorf("Expected OK deleting the extended message, got %v", response.Code)
+	}
+}
+
+func TestDeadLetterMetadata(t *testing.T) {
+	config := config
+	config.Type = queue.QueueTypeStandard
+	config.MaxReceiveCount = 1
+	config.MaxMessageSize = 16
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	queueIO.InsertQueue(time.Now(), queue.Message{ID: "msg-1", Body: []byte("Flaky")})
+	queueIO.InsertQueue(time.Now(), queue.Message{ID: "msg-2", Body: []byte("Poison")})
+
+	// A nack makes the message visible again and keeps the consumer's reason
+	first := queueIO.PeekQueue(time.Now())
+	if response := queueIO.NackMessage(time.Now(), first.ReceiptHandle, "database unavailable"); response.Code != queue.OK {
+		t.Fatalf("Expected OK, got %v", response.Code)
+	}
+	second := queueIO.PeekQueue(time.Now())
+	if second.Message.ID != "msg-2" {
+		t.Fatalf("Expected msg-1 to be dead lettered on its second receive and msg-2 received, got %s", second.Message.ID)
+	}
+	if response := queueIO.RejectMessage(time.Now(), second.ReceiptHandle, "malformed payload"); response.Code != queue.OK {
+		t.Errorf("Expected OK, got %v", response.Code)
+	}
+	if response := queueIO.RejectMessage(time.Now(), second.ReceiptHandle, "malformed payload"); response.Code != queue.INVALID_RECEIPT_HANDLE {
+		t.Errorf("Expected INVALID_RECEIPT_HANDLE rejecting twice, got %v", response.Code)
+	}
+
+	// A moved message larger than the queue accepts is dead lettered as invalid
+	queueIO.InsertMoved(time.Now(), []queue.Message{{ID: "msg-3", Body: []byte("Larger than sixteen bytes")}})
+
+	deadLetters := queueIO.SnapshotQueue().DeadLetterQueue
+	if len(deadLetters) != 3 {
+		t.Fatalf("Expected 3 dead letters, got %d", len(deadLetters))
+	}
+	expected := []struct {
+		id            string
+		reason        queue.DeadLetterReason
+		receiveCount  uint16
+		failureReason string
+	}{
+		{"msg-1", queue.DeadLetterMaxReceives, 1, "database unavailable"},
+		{"msg-2", queue.DeadLetterRejected, 1, "malformed payload"},
+		{"msg-3", queue.DeadLetterInvalid, 0, ""},
+	}
+	for i, want := range expected {
+		message := deadLetters[i]
+		if message.ID != want.id || message.DeadLetter == nil {
+			t.Errorf("Expected %s with dead letter info, got %s", want.id, message.ID)
+			continue
+		}
+		info := message.DeadLetter
+		if info.Reason != want.reason || info.ReceiveCount != want.receiveCount || info.SourceQueue != "id" || info.DeadLetteredAt.IsZero() {
+			t.Errorf("Unexpected dead letter info for %s: %+v", want.id, info)
+		}
+		if message.FailureReason != want.failureReason {
+			t.Errorf("Expected failure reason %q for %s, got %q", want.failureReason, want.id, message.FailureReason)
+		}
+	}
+}
+
+func TestPurgeQueue(t *testing.T) {
+	config := config
+	config.Type = queue.QueueTypeStandard
+	config.MaxReceiveCount = 1
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	queueIO.InsertQueue(time.Now(), queue.Message{ID: "msg-1", Body: []byte("Dead letter")})
+	queueIO.InsertQueue(time.Now(), queue.Message{ID: "msg-2", Body: []byte("In flight")})
+	queueIO.InsertQueue(time.Now(), queue.Message{ID: "msg-3", Body: []byte("Ready")})
+	queueIO.InsertQueue(time.Now(), queue.Message{ID: "msg-4", Body: []byte("Delayed"), DelaySeconds: 60})
+	queueIO.RejectMessage(time.Now(), queueIO.PeekQueue(time.Now()).ReceiptHandle, "bad")
+	queueIO.PeekQueue(time.Now())
+
+	if response := queueIO.PurgeQueue(time.Now(), "everything"); response.Code != queue.INVALID_PURGE_TARGET {
+		t.Errorf("Expected INVALID_PURGE_TARGET, got %v", response.Code)
+	}
+	response := queueIO.PurgeQueue(time.Now(), queue.PurgeMessages)
+	if response.Code != queue.OK || response.Purged != 3 {
+		t.Errorf("Expected 3 messages purged, got %d with code %v", response.Purged, response.Code)
+	}
+	if response := queueIO.PurgeQueue(time.Now(), queue.PurgeDeadLetters); response.Code != queue.PURGE_IN_PROGRESS {
+		t.Errorf("Expected PURGE_IN_PROGRESS within the cooldown, got %v", response.Code)
+	}
+
+	snapshot := queueIO.SnapshotQueue()
+	if len(snapshot.Messages)+len(snapshot.InFlight)+len(snapshot.Delayed) != 0 || len(snapshot.DeadLetterQueue) != 1 {
+		t.Errorf("Expected only the dead letter left, got %d ready, %d in flight, %d delayed and %d dead letters",
+			len(snapshot.Messages), len(snapshot.InFlight), len(snapshot.Delayed), len(snapshot.DeadLetterQueue))
+	}
+
+	// The cooldown survives a restore, so a new leader refuses the purge too
+	snapshot.LastPurgedAt = time.Now().Add(-queue.PurgeCooldown)
+	restored := queue.RestoreQueue(snapshot)
+	defer restored.Close()
+	if response := restored.PurgeQueue(time.Now(), queue.PurgeAll); response.Code != queue.OK || response.Purged != 1 {
+		t.Errorf("Expected the dead letter purged after the cooldown, got %d with code %v", response.Purged, response.Code)
+	}
+	if response := restored.PurgeQueue(time.Now(), queue.PurgeAll); response.Code != queue.PURGE_IN_PROGRESS {
+		t.Errorf("Expected PURGE_IN_PROGRESS, got %v", response.Code)
+	}
+}
+
+func TestDeleteMessageByID(t *testing.T) {
+	for _, queueType := range []queue.QueueType{queue.QueueTypeStandard, queue.QueueTypePriority} {
+		config := config
+		config.Type = queueType
+		queueIO := queue.MakeQueue("id", config)
+		now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+		for _, id := range []string{"msg-1", "msg-2", "msg-3", "msg-4"} {
+			queueIO.InsertQueue(now, queue.Message{ID: id, Body: []byte(id)})
+		}
+		queueIO.InsertQueue(now, queue.Message{ID: "delayed", Body: []byte("Delayed"), DelaySeconds: 60})
+		queueIO.InsertQueue(now, queue.Message{ID: "scheduled", Body: []byte("Scheduled"), DeliverAt: now.Add(time.Minute)})
+		received := queueIO.PeekQueue(now)
+
+		// Messages are deleted wherever they are: in flight, in the middle of the queue, delayed or scheduled
+		for _, id := range []string{received.Message.ID, "msg-3", "delayed", "scheduled"} {
+			if response := queueIO.DeleteMessage(now, id); response.Code != queue.OK {
+				t.Errorf("%s: expected OK deleting %s, got %v", queueType, id, response.Code)
+			}
+		}
+		if response := queueIO.DeleteMessage(now, "msg-3"); response.Code != queue.MESSAGE_NOT_FOUND {
+			t.Errorf("%s: expected MESSAGE_NOT_FOUND deleting twice, got %v", queueType, response.Code)
+		}
+		if response := queueIO.DeleteMessage(now, "unknown"); response.Code != queue.MESSAGE_NOT_FOUND {
+			t.Errorf("%s: expected MESSAGE_NOT_FOUND, got %v", queueType, response.Code)
+		}
+		if response := queueIO.RemoveQueue(now, received.ReceiptHandle); response.Code != queue.INVALID_RECEIPT_HANDLE {
+			t.Errorf("%s: expected the receipt handle of a deleted message to be invalid, got %v", queueType, response.Code)
+		}
+
+		// The deleted messages are gone from snapshots, and a restored queue can still delete by ID
+		snapshot := queueIO.SnapshotQueue()
+		queueIO.Close()
+		if len(snapshot.Messages) != 2 || len(snapshot.InFlight)+len(snapshot.Delayed)+len(snapshot.Scheduled) != 0 {
+			t.Fatalf("%s: expected msg-2 and msg-4 left, got %+v", queueType, snapshot.Messages)
+		}
+		restored := queue.RestoreQueue(snapshot)
+		if response := restored.DeleteMessage(now, "msg-2"); response.Code != queue.OK {
+			t.Errorf("%s: expected OK deleting from the restored queue, got %v", queueType, response.Code)
+		}
+		if response := restored.PeekQueue(now.Add(2 * time.Minute)); response.Message.ID != "msg-4" {
+			t.Errorf("%s: expected msg-4, got %s", queueType, response.Message.ID)
+		}
+		if response := restored.PeekQueue(now.Add(2 * time.Minute)); response.Code != queue.EMPTY_QUEUE {
+			t.Errorf("%s: expected EMPTY_QUEUE, got %v", queueType, response.Code)
+		}
+		restored.Close()
+	}
+}
+
+func TestDeleteRestoredMessageWithoutSequenceNumber(t *testing.T) {
+	standard := config
+	standard.Type = queue.QueueTypeStandard
+
+	// Snapshots written before messages had a SequenceNumber restore every message with zero
+	now := time.Now()
+	queueIO := queue.RestoreQueue(queue.Queue{
+		ID:     "id",
+		Config: standard,
+		Messages: []queue.Message{
+			{ID: "a", Body: []byte("a"), TimeStamp: now},
+			{ID: "b", Body: []byte("b"), TimeStamp: now},
+			{ID: "c", Body: []byte("c"), TimeStamp: now},
+		},
+	})
+	defer queueIO.Close()
+
+	if response := queueIO.DeleteMessage(now, "a"); response.Code != queue.OK {
+		t.Fatalf("Expected OK deleting a, got %v", response.Code)
+	}
+
+	snapshot := queueIO.SnapshotQueue()
+	if len(snapshot.Messages) != 2 || snapshot.Messages[0].ID != "b" || snapshot.Messages[1].ID != "c" {
+		t.Errorf("Expected b and c in the snapshot, got %v", snapshot.Messages)
+	}
+	if snapshot.SequenceNumber != 3 {
+		t.Errorf("Expected the restored messages numbered up to 3, got %d", snapshot.SequenceNumber)
+	}
+
+	// A message sent after the restore is numbered after them
+	queueIO.InsertQueue(now, queue.Message{ID: "d"})
+	var received []string
+	for {
+		response := queueIO.PeekQueue(now)
+		if response.Code != queue.OK {
+			break
+		}
+		received = append(received, response.Message.ID)
+	}
+	if fmt.Sprint(received) != "[b c d]" {
+		t.Errorf("Expected [b c d], got %v", received)
+	}
+}
+
+func TestSendIgnoresReceiveFields(t *testing.T) {
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	now := time.Now()
+	queueIO.InsertQueue(now, queue.Message{
+		ID:                    "msg-1",
+		ReceiveCount:          50,
+		FirstReceiveTimestamp: now.Add(-time.Hour),
+		FailureReason:         "forged",
+		DeadLetter:            &queue.DeadLetterInfo{SourceQueue: "elsewhere"},
+	})
+
+	response := queueIO.PeekQueue(now)
+	if response.Code != queue.OK {
+		t.Fatalf("Expected the message to be received, got %v", response.Code)
+	}
+	message := response.Message
+	if message.ReceiveCount != 1 || !message.FirstReceiveTimestamp.Equal(now) || message.FailureReason != "" || message.DeadLetter != nil {
+		t.Errorf("Expected a first receive without dead letter metadata, got %+v", message)
+	}
+}
+
+func TestStaleReceiptHandleAfterRequeue(t *testing.T) {
+	queueIO := queue.MakeQueue("id", config)
+	defer queueIO.Close()
+
+	now := time.Now()
+	queueIO.InsertQueue(now, queue.Message{ID: "msg-1"})
+	first := queueIO.PeekQueue(now)
+	queueIO.RejectMessage(now, first.ReceiptHandle, "poison")
+
+	// Requeueing starts the receive count over, the new receive still gets a new handle
+	queueIO.Requeue(now)
+	second := queueIO.PeekQueue(now)
+	if second.Code != queue.OK || second.Message.ReceiveCount != 1 {
+		t.Fatalf("Expected msg-1 received once after the requeue, got %v %+v", second.Code, second.Message)
+	}
+	if second.ReceiptHandle == first.ReceiptHandle {
+		t.Fatalf("Expected a new receipt handle after the requeue, got %s again", first.ReceiptHandle)
+	}
+	if response := queueIO.RemoveQueue(now, first.ReceiptHandle); response.Code != queue.INVALID_RECEIPT_HANDLE {
+		t.Errorf("Expected INVALID_RECEIPT_HANDLE for the stale handle, got %v", response.Code)
+	}
+	if response := queueIO.RemoveQueue(now, second.ReceiptHandle); response.Code != queue.OK {
+		t.Errorf("Expected OK for the current handle, got %v", response.Code)
+	}
+}
+
+func TestDeleteRequeuedDeadLetterWithoutSequenceNumber(t *testing.T) {
+	standard := config
+	standard.Type = queue.QueueTypeStandard
+
+	// Dead letters of a snapshot written before messages had a SequenceNumber
+	now := time.Now()
+	queueIO := queue.RestoreQueue(queue.Queue{
+		ID:     "id",
+		Config: standard,
+		DeadLetterQueue: []queue.Message{
+			{ID: "a", Body: []byte("a"), TimeStamp: now},
+			{ID: "b", Body: []byte("b"), TimeStamp: now},
+		},
+	})
+	defer queueIO.Close()
+
+	queueIO.Requeue(now)
+	queueIO.Requeue(now)
+	if response := queueIO.DeleteMessage(now, "b"); response.Code != queue.OK {
+		t.Fatalf("Expected OK deleting b, got %v", response.Code)
+	}
+
+	if response := queueIO.PeekQueue(now); response.Message.ID != "a" {
+		t.Errorf("Expected a, got %q", response.Message.ID)
+	}
+	if response := queueIO.PeekQueue(now); response.Code != queue.EMPTY_QUEUE {
+		t.Errorf("Expected EMPTY_QUEUE once a is received, got %v with %s", response.Code, response.Message.ID)
+	}
+}
@@ -0,0 +1,245 @@
+package unit_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Weile-Zheng/simplyQ/internal/queue"
+	"github.com/Weile-Zheng/simplyQ/internal/queue_manager"
+	raftnode "github.com/Weile-Zheng/simplyQ/internal/raft_fsm"
+	"github.com/hashicorp/raft"
+)
+
+func TestRestoreSnapshotFormats(t *testing.T) {
+	queues := map[string]queue.Queue{
+		"TestQueue": {
+			ID:       "TestQueue",
+			Config:   queue.QueueConfig{Name: "TestQueue", Type: queue.QueueTypeStandard},
+			Messages: []queue.Message{{ID: "msg-1", Body: []byte("Snapshotted")}},
+		},
+	}
+	tasks := []queue_manager.RedriveTask{{ID: "task-1", DeadLetterQueue: "TestQueue", Status: queue_manager.RedriveRunning}}
+
+	legacy, _ := json.Marshal(queues)
+	versioned, _ := json.Marshal(map[string]any{
+		"version":       2,
+		"queues":        queues,
+		"redrive_tasks": tasks,
+	})
+
+	for name, data := range map[string][]byte{"legacy": legacy, "versioned": versioned} {
+		qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+		fsm := raftnode.FSM{QueueManager: &qm}
+		if err := fsm.Restore(io.NopCloser(strings.NewReader(string(data)))); err != nil {
+			t.Fatalf("Failed to restore %s snapshot: %v", name, err)
+		}
+
+		if response := qm.PeekMessage(time.Now(), "TestQueue"); response.Message.ID != "msg-1" {
+			t.Errorf("Expected msg-1 from the %s snapshot, got %s", name, response.Message.ID)
+		}
+		running := qm.RunningRedriveTasks()
+		if name == "versioned" && (len(running) != 1 || running[0] != "task-1") {
+			t.Errorf("Expected task-1 running from the versioned snapshot, got %v", running)
+		}
+		if name == "legacy" && len(running) != 0 {
+			t.Errorf("Expected no redrive tasks from the legacy snapshot, got %v", running)
+		}
+	}
+}
+
+// snapshotBuffer is an in memory raft.SnapshotSink.
+type snapshotBuffer struct {
+	bytes.Buffer
+}
+
+func (b *snapshotBuffer) ID() string    { return "test" }
+func (b *snapshotBuffer) Cancel() error { return nil }
+func (b *snapshotBuffer) Close() error  { return nil }
+
+func applyCommand(t *testing.T, fsm *raftnode.FSM, command queue_manager.Command) any {
+	data, err := json.Marshal(command)
+	if err != nil {
+		t.Fatalf("Failed to marshal command: %v", err)
+	}
+	return fsm.Apply(&raft.Log{Data: data})
+}
+
+func TestReplicasConverge(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(offset time.Duration) time.Time { return start.Add(offset) }
+	send := func(offset time.Duration, id string) queue_manager.Command {
+		return queue_manager.Command{
+			Type:      queue_manager.SEND_MESSAGE,
+			Timestamp: at(offset),
+			QueueID:   "Orders",
+			Message:   queue.Message{ID: id, Body: []byte(id), TimeStamp: at(offset)},
+		}
+	}
+	receive := func(offset time.Duration) queue_manager.Command {
+		return queue_manager.Command{Type: queue_manager.PEEK_MESSAGE, Timestamp: at(offset), QueueID: "Orders"}
+	}
+
+	// The log spans hours but is applied in milliseconds, only the command timestamps move time forward.
+	log := []queue_manager.Command{
+		{Type: queue_manager.CREATE_QUEUE, Timestamp: at(0), QueueConfig: queue.QueueConfig{Name: "DeadLetters", Type: queue.QueueTypeStandard}},
+		{Type: queue_manager.CREATE_QUEUE, Timestamp: at(0), QueueConfig: queue.QueueConfig{
+			Name:              "Orders",
+			Type:              queue.QueueTypeStandard,
+			VisibilityTimeout: 30 * time.Second,
+			RetentionPeriod:   time.Hour,
+			RedrivePolicy:     queue.RedrivePolicy{DeadLetterTargetQueue: "DeadLetters", MaxReceiveCount: 2},
+		}},
+		send(time.Second, "msg-1"),
+		send(2*time.Second, "msg-2"),
+		receive(3 * time.Second),
+		receive(4 * time.Second),
+		receive(time.Minute),     // msg-1 is visible again after its visibility timeout.
+		receive(2 * time.Minute), // msg-2 is visible again, its second receive.
+		send(3*time.Minute, "msg-3"),
+		receive(4 * time.Minute), // msg-1 and msg-2 reached MaxReceiveCount and are dead lettered, msg-3 is received.
+		send(30*time.Minute, "msg-4"),
+		receive(45 * time.Minute),
+		send(2*time.Hour, "msg-5"), // every message but msg-5 expired and is dead lettered.
+		receive(2*time.Hour + time.Second),
+	}
+
+	replicas := make([]*raftnode.FSM, 2)
+	for i := range replicas {
+		qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+		replicas[i] = &raftnode.FSM{QueueManager: &qm}
+	}
+	half := len(log) / 2
+	responses := make([][]any, len(replicas))
+	for i, command := range log {
+		for r, fsm := range replicas {
+			responses[r] = append(responses[r], applyCommand(t, fsm, command))
+		}
+
+		// A replica that joins halfway restores the snapshot of the first one and replays the rest.
+		if i == half {
+			snapshot, err := replicas[0].Snapshot()
+			if err != nil {
+				t.Fatalf("Failed to take snapshot: %v", err)
+			}
+			var sink snapshotBuffer
+			if err := snapshot.Persist(&sink); err != nil {
+				t.Fatalf("Failed to persist snapshot: %v", err)
+			}
+			qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+			joined := &raftnode.FSM{QueueManager: &qm}
+			if err := joined.Restore(io.NopCloser(&sink)); err != nil {
+				t.Fatalf("Failed to restore snapshot: %v", err)
+			}
+			replicas = append(replicas, joined)
+			responses = append(responses, make([]any, len(responses[0])))
+		}
+	}
+
+	for r := 1; r < len(replicas); r++ {
+		for i := half + 1; i < len(log); i++ {
+			if !reflect.DeepEqual(responses[0][i], responses[r][i]) {
+				t.Errorf("Replica %d answered entry %d differently: %+v, expected %+v", r, i, responses[r][i], responses[0][i])
+			}
+		}
+	}
+
+	expected, _ := json.Marshal(replicas[0].QueueManager.ViewAllQueues())
+	for r := 1; r < len(replicas); r++ {
+		if state, _ := json.Marshal(replicas[r].QueueManager.ViewAllQueues()); !bytes.Equal(state, expected) {
+			t.Errorf("Replica %d diverged:\n%s\nexpected:\n%s", r, state, expected)
+		}
+	}
+
+	deadLetters := replicas[0].QueueManager.ViewAllMessages("DeadLetters").Messages
+	reasons := make(map[string]queue.DeadLetterReason)
+	for _, message := range deadLetters {
+		reasons[message.ID] = message.DeadLetter.Reason
+	}
+	expectedReasons := map[string]queue.DeadLetterReason{
+		"msg-1": queue.DeadLetterMaxReceives,
+		"msg-2": queue.DeadLetterMaxReceives,
+		"msg-3": queue.DeadLetterExpired,
+		"msg-4": queue.DeadLetterExpired,
+	}
+	if !reflect.DeepEqual(reasons, expectedReasons) {
+		t.Errorf("Expected dead letters %v, got %v", expectedReasons, reasons)
+	}
+}
+
+func TestApplyBaselineLog(t *testing.T) {
+	// Entries as the first release wrote them: numbered command types and no timestamp.
+	entries := []string{
+		`{"type":0,"queue_config":{"Name":"Orders","Type":"standard"}}`,
+		`{"type":2,"queue_id":"Orders","message":{"ID":"msg-1","Body":"hello"}}`,
+		`{"type":2,"queue_id":"Orders","message":{"ID":"msg-2","Body":"hello"}}`,
+		`{"type":3,"queue_id":"Orders"}`,
+		`{"type":5,"queue_id":"Orders"}`,
+	}
+
+	qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+	fsm := raftnode.FSM{QueueManager: &qm}
+	var results []any
+	for _, entry := range entries {
+		results = append(results, fsm.Apply(&raft.Log{Data: []byte(entry), AppendedAt: time.Now()}))
+	}
+
+	if response, ok := results[3].(queue.Response); !ok || response.Code != queue.OK || response.Message.ID != "msg-1" {
+		t.Errorf("Expected entry 3 to receive msg-1, got %+v", results[3])
+	}
+	view, ok := results[4].(queue_manager.QueueView)
+	if !ok {
+		t.Fatalf("Expected entry 4 to view the queue, got %T", results[4])
+	}
+	if len(view.Messages) != 1 || view.Messages[0].ID != "msg-2" || string(view.Messages[0].Body) != "hello" {
+		t.Errorf("Expected msg-2 left in the queue, got %+v", view.Messages)
+	}
+}
+
+func TestAppliedTimeNeverGoesBack(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	qm := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+	fsm := &raftnode.FSM{QueueManager: &qm}
+
+	applyCommand(t, fsm, queue_manager.Command{Type: queue_manager.CREATE_QUEUE, Timestamp: start, QueueConfig: queue.QueueConfig{
+		Name:              "Orders",
+		Type:              queue.QueueTypeStandard,
+		VisibilityTimeout: 30 * time.Second,
+	}})
+	applyCommand(t, fsm, queue_manager.Command{Type: queue_manager.SEND_MESSAGE, Timestamp: start, QueueID: "Orders", Message: queue.Message{ID: "msg-1"}})
+	received := applyCommand(t, fsm, queue_manager.Command{Type: queue_manager.PEEK_MESSAGE, Timestamp: start.Add(10 * time.Minute), QueueID: "Orders"}).(queue.Response)
+
+	// A replica restored from a snapshot continues from the same time
+	snapshot, err := fsm.Snapshot()
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+	var sink snapshotBuffer
+	if err := snapshot.Persist(&sink); err != nil {
+		t.Fatalf("Failed to persist snapshot: %v", err)
+	}
+	restoredManager := queue_manager.NewQueueManager(queue_manager.QueueManagerConfig{Name: "TestManager"})
+	restored := &raftnode.FSM{QueueManager: &restoredManager}
+	if err := restored.Restore(io.NopCloser(&sink)); err != nil {
+		t.Fatalf("Failed to restore snapshot: %v", err)
+	}
+
+	for name, replica := range map[string]*raftnode.FSM{"original": fsm, "restored": restored} {
+		// Stamped by a leader whose clock is ten minutes behind, the extension still counts from the receive
+		applyCommand(t, replica, queue_manager.Command{
+			Type:              queue_manager.CHANGE_VISIBILITY,
+			Timestamp:         start,
+			QueueID:           "Orders",
+			ReceiptHandle:     received.ReceiptHandle,
+			VisibilityTimeout: 30 * time.Second,
+		})
+		response := applyCommand(t, replica, queue_manager.Command{Type: queue_manager.PEEK_MESSAGE, Timestamp: start.Add(10*time.Minute + time.Second), QueueID: "Orders"})
+		if response := response.(queue.Response); response.Code != queue.EMPTY_QUEUE {
+			t.Errorf("Expected msg-1 to stay hidden on the %s replica, got %v with %s", name, response.Code, response.Message.ID)
+		}
+	}
+}
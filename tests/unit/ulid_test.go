@@ -0,0 +1,44 @@
+package unit_test
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Weile-Zheng/simplyQ/internal/ulid"
+)
+
+func TestULIDsSortByTime(t *testing.T) {
+	generator := ulid.NewGenerator()
+	start := time.UnixMilli(1_700_000_000_000)
+
+	var ids []string
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		// Many IDs share a millisecond and the clock goes back once, they still have to increase
+		now := start.Add(time.Duration(i/100) * time.Millisecond)
+		if i == 500 {
+			now = start
+		}
+		id, err := generator.New(now)
+		if err != nil {
+			t.Fatalf("Failed to generate ID: %v", err)
+		}
+		if len(id) != 26 {
+			t.Fatalf("Expected a 26 character ID, got %q", id)
+		}
+		if seen[id] {
+			t.Fatalf("Duplicate ID %s", id)
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if !sort.StringsAreSorted(ids) {
+		t.Errorf("Expected IDs in the order they were generated")
+	}
+
+	// The first 10 characters encode the millisecond, 1700000000000 is 01HF7YAT00 in Crockford's base32
+	if prefix := ids[0][:10]; prefix != "01HF7YAT00" {
+		t.Errorf("Expected time prefix 01HF7YAT00, got %s", prefix)
+	}
+}